@@ -0,0 +1,62 @@
+// Mailcatcher captures all mail sent over smtp and shows it in the web ui.
+// It is intended for the local development, run the worker with the mail_sender
+// pointed to the smtp address and tls.mode PLAINTEXT.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Falokut/email_service/internal/mailcatcher"
+	"github.com/Falokut/email_service/pkg/logging"
+)
+
+func main() {
+	smtpAddr := flag.String("smtp-addr", ":1025", "smtp server address")
+	httpAddr := flag.String("http-addr", ":8025", "web ui address")
+	maxMessages := flag.Int("max-messages", 500, "number of kept messages")
+	flag.Parse()
+
+	logging.NewEntry(logging.ConsoleOutput, logging.TextFormat)
+	logger := logging.GetLogger()
+
+	store := mailcatcher.NewStore(*maxMessages)
+	smtpServer := mailcatcher.NewSMTPServer(store, logger.Logger)
+	httpServer := &http.Server{
+		Addr:              *httpAddr,
+		Handler:           mailcatcher.NewHandler(store, logger.Logger),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errCh := make(chan error, 2)
+	go func() {
+		logger.Infof("smtp server listening on %s", *smtpAddr)
+		errCh <- smtpServer.ListenAndServe(*smtpAddr)
+	}()
+	go func() {
+		logger.Infof("web ui listening on %s", *httpAddr)
+		if err := httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			errCh <- err
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case <-quit:
+	case err := <-errCh:
+		logger.Error(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	httpServer.Shutdown(ctx)
+	smtpServer.Close()
+	logger.Infoln("Shutted down successfully")
+}
@@ -2,22 +2,31 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/Falokut/email_service/internal/config"
 	"github.com/Falokut/email_service/internal/email"
 	"github.com/Falokut/email_service/internal/events"
+	"github.com/Falokut/email_service/internal/health"
+	"github.com/Falokut/email_service/internal/httpmail"
+	"github.com/Falokut/email_service/internal/idempotency"
+	"github.com/Falokut/email_service/internal/metrics"
 	"github.com/Falokut/email_service/internal/screeningsservice"
 	"github.com/Falokut/email_service/internal/service"
+	"github.com/Falokut/email_service/internal/tracing"
+	"github.com/Falokut/email_service/internal/wallet"
 	"github.com/Falokut/email_service/pkg/logging"
 	"github.com/sirupsen/logrus"
 )
 
 func main() {
-	logging.NewEntry(logging.ConsoleOutput)
+	logging.NewEntry(logging.ConsoleOutput, logging.TextFormat)
 	logger := logging.GetLogger()
 
 	cfg := config.GetConfig()
@@ -25,9 +34,29 @@ func main() {
 	if err != nil {
 		logger.Fatal(err)
 	}
+	log_format, err := logging.ParseFormat(cfg.LogFormat)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	logging.NewEntry(logging.ConsoleOutput, log_format)
+	logger = logging.GetLogger()
 
 	logger.Logger.SetLevel(log_level)
 
+	shutdownTracer, err := tracing.NewTracerProvider(context.Background(), cfg.Tracing)
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracer(ctx); err != nil {
+			logger.Error(err)
+		}
+	}()
+
 	screeningService, err := screeningsservice.NewScreeningsService(
 		cfg.CinemaServiceConfig.Addr, cfg.CinemaServiceConfig.SecureConfig,
 		cfg.MoviesServiceConfig.Addr, cfg.MoviesServiceConfig.SecureConfig, logger.Logger)
@@ -38,19 +67,28 @@ func main() {
 	}
 	defer screeningService.Shutdown()
 
-	subjects := map[service.MailSubjectType]string{
-		service.EmailVerfication: cfg.EmailVerificationConfig.Subject,
-		service.OrderCreated:     cfg.OrderCreatedConfig.Subject,
-		service.PasswordChanging: cfg.ChangePasswordConfig.Subject,
+	routes, err := events.NewRoutingTable(getRoutes(cfg))
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+
+	mailSender, shutdownMailSender, err := newMailSender(cfg, logger.Logger)
+	if err != nil {
+		logger.Error(err)
+		return
 	}
-	templateNames := map[service.MailSubjectType]string{
-		service.EmailVerfication: cfg.EmailVerificationConfig.Template,
-		service.OrderCreated:     cfg.OrderCreatedConfig.Template,
-		service.PasswordChanging: cfg.ChangePasswordConfig.Template,
+	defer shutdownMailSender()
+	var passBuilder service.PassBuilder
+	if cfg.WalletPass.Enabled {
+		passBuilder, err = wallet.NewPassBuilder(cfg.WalletPass)
+		if err != nil {
+			logger.Error(err)
+			return
+		}
 	}
 
-	mailSender := email.NewMailSender(cfg.MailSenderCfg, logger.Logger)
-	service, err := service.NewMailService(mailSender, screeningService, subjects, templateNames)
+	service, err := service.NewMailService(mailSender, screeningService, cfg.TemplatesDir, passBuilder, logger.Logger)
 	if err != nil {
 		logger.Error(err)
 		return
@@ -59,37 +97,250 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	logger.Infoln("event consumers initializing")
-	var wg sync.WaitGroup
-	wg.Add(1)
 	go func() {
-		logger.Info("Running orders events consumer")
-		ordersEventsConsumer := events.NewOrdersEventsConsumer(getKafkaReaderConfig(cfg.OrdersEventsConfig),
-			logger.Logger, service)
-		ordersEventsConsumer.Run(ctx)
-		wg.Done()
+		logger.Info("Running metrics server")
+		if err := metrics.RunMetricServer(ctx, cfg.PrometheusConfig.ServerConfig, logger.Logger); err != nil {
+			logger.Error(err)
+		}
 	}()
-	wg.Add(1)
+
+	probes := health.NewServer(cfg.Healthcheck, logger.Logger)
+	probes.AddReadinessCheck("screenings_service", screeningService.Ping)
+	if p, ok := mailSender.(pinger); ok {
+		probes.AddReadinessCheck("mail_sender", p.Ping)
+	}
+
+	dedup, err := idempotency.NewStore(cfg.Deduplication, logger.Logger)
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	if dedup != nil {
+		defer dedup.Close()
+	}
+
+	logger.Infoln("event consumers initializing")
+	var consumers []consumer
+	if len(routes.Topics(events.OrderCreatedHandler)) > 0 {
+		// kafka settings are set per consumer, so each consumer has its own broker
+		broker, err := events.NewBroker(getBrokerConfig(cfg.Broker, cfg.OrdersEventsConfig), logger.Logger)
+		if err != nil {
+			logger.Error(err)
+			return
+		}
+		defer broker.Close()
+
+		ordersEventsConsumer, err := events.NewOrdersEventsConsumer(getConsumerConfig(cfg.OrdersEventsConfig),
+			broker, logger.Logger, service, probes, dedup, routes)
+		if err != nil {
+			logger.Error(err)
+			return
+		}
+		probes.AddReadinessCheck("orders_events_broker", ordersEventsConsumer.Ping)
+		consumers = append(consumers, ordersEventsConsumer)
+	}
+	if len(routes.Topics(events.TokenHandler)) > 0 {
+		broker, err := events.NewBroker(getBrokerConfig(cfg.Broker, cfg.TokensDeliveryRequestsConfig), logger.Logger)
+		if err != nil {
+			logger.Error(err)
+			return
+		}
+		defer broker.Close()
+
+		tokensDeliveryRequestsConsumer, err := events.NewTokensDeliveryRequestsConsumer(getConsumerConfig(cfg.TokensDeliveryRequestsConfig),
+			broker, logger.Logger, service, probes, dedup, routes)
+		if err != nil {
+			logger.Error(err)
+			return
+		}
+		probes.AddReadinessCheck("tokens_delivery_requests_broker", tokensDeliveryRequestsConsumer.Ping)
+		consumers = append(consumers, tokensDeliveryRequestsConsumer)
+	}
+
 	go func() {
-		logger.Info("Running tokens delivery request consumer")
-		tokensDeliveryRequestsConsumer := events.NewTokensDeliveryRequestsConsumer(getKafkaReaderConfig(cfg.TokensDeliveryRequestsConfig),
-			logger.Logger, service)
-		tokensDeliveryRequestsConsumer.Run(ctx)
-		wg.Done()
+		logger.Info("Running healthcheck server")
+		if err := probes.Run(ctx); err != nil {
+			logger.Error(err)
+		}
 	}()
 
+	logger.Info("Running event consumers")
+	var wg sync.WaitGroup
+	for _, c := range consumers {
+		wg.Add(1)
+		go func(c consumer) {
+			c.Run(ctx)
+			wg.Done()
+		}(c)
+	}
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGHUP, syscall.SIGTERM)
 
 	<-quit
+	cancel()
 	wg.Wait()
 	logger.Infoln("Shutted down successfully")
 }
 
+func getConsumerConfig(cfg config.KafkaReaderConfig) events.ConsumerConfig {
+	return events.ConsumerConfig{
+		Group: cfg.GroupID,
+		Retry: events.RetryConfig{
+			MaxAttempts:    cfg.Retry.MaxAttempts,
+			InitialBackoff: cfg.Retry.InitialBackoff,
+			MaxBackoff:     cfg.Retry.MaxBackoff,
+			Multiplier:     cfg.Retry.Multiplier,
+		},
+		Pool: events.PoolConfig{
+			Workers:     cfg.Pool.Workers,
+			MaxInFlight: cfg.Pool.MaxInFlight,
+			OrderBy:     cfg.Pool.OrderBy,
+		},
+	}
+}
+
 func getKafkaReaderConfig(cfg config.KafkaReaderConfig) events.KafkaReaderConfig {
 	return events.KafkaReaderConfig{
-		Brokers:          cfg.Brokers,
-		GroupID:          cfg.GroupID,
-		ReadBatchTimeout: cfg.ReadBatchTimeout,
+		Brokers:           cfg.Brokers,
+		ClientID:          cfg.ClientID,
+		ReadBatchTimeout:  cfg.ReadBatchTimeout,
+		StartOffset:       cfg.StartOffset,
+		MinBytes:          cfg.MinBytes,
+		MaxBytes:          cfg.MaxBytes,
+		SessionTimeout:    cfg.SessionTimeout,
+		HeartbeatInterval: cfg.HeartbeatInterval,
+		RebalanceStrategy: cfg.RebalanceStrategy,
+		CommitInterval:    cfg.CommitInterval,
+		SASL: events.SASLConfig{
+			Mechanism: cfg.SASL.Mechanism,
+			Username:  cfg.SASL.Username,
+			Password:  cfg.SASL.Password,
+		},
+		TLS: getTLSConfig(cfg.TLS),
+	}
+}
+
+func getBrokerConfig(cfg config.BrokerConfig, kafkaCfg config.KafkaReaderConfig) events.BrokerConfig {
+	return events.BrokerConfig{
+		Type:  cfg.Type,
+		Kafka: getKafkaReaderConfig(kafkaCfg),
+		NATS: events.NATSConfig{
+			URL:       cfg.NATS.URL,
+			Stream:    cfg.NATS.Stream,
+			Username:  cfg.NATS.Username,
+			Password:  cfg.NATS.Password,
+			Token:     cfg.NATS.Token,
+			CredsFile: cfg.NATS.CredsFile,
+			AckWait:   cfg.NATS.AckWait,
+			TLS:       getTLSConfig(cfg.NATS.TLS),
+		},
+		AMQP: events.AMQPConfig{
+			URL:      cfg.AMQP.URL,
+			Prefetch: cfg.AMQP.Prefetch,
+			TLS:      getTLSConfig(cfg.AMQP.TLS),
+		},
+	}
+}
+
+func getTLSConfig(cfg config.BrokerTLSConfig) events.TLSConfig {
+	return events.TLSConfig{
+		Enabled:            cfg.Enabled,
+		CAFile:             cfg.CAFile,
+		CertFile:           cfg.CertFile,
+		KeyFile:            cfg.KeyFile,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+}
+
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+type consumer interface {
+	Run(ctx context.Context)
+}
+
+// default topics of the routes made from the legacy mail sections
+const (
+	defaultOrderCreatedTopic      = "order_created"
+	defaultEmailVerificationTopic = "email_verification_delivery_request"
+	defaultChangePasswordTopic    = "password_change_delivery_request"
+)
+
+func getRoutes(cfg *config.Config) []events.Route {
+	if len(cfg.Routes) == 0 {
+		return []events.Route{
+			{
+				Topic:    valueOrDefault(cfg.OrderCreatedConfig.Topic, defaultOrderCreatedTopic),
+				Handler:  events.OrderCreatedHandler,
+				Subject:  cfg.OrderCreatedConfig.Subject,
+				Template: cfg.OrderCreatedConfig.Template,
+			},
+			{
+				Topic:    valueOrDefault(cfg.EmailVerificationConfig.Topic, defaultEmailVerificationTopic),
+				Handler:  events.TokenHandler,
+				Subject:  cfg.EmailVerificationConfig.Subject,
+				Template: cfg.EmailVerificationConfig.Template,
+			},
+			{
+				Topic:    valueOrDefault(cfg.ChangePasswordConfig.Topic, defaultChangePasswordTopic),
+				Handler:  events.TokenHandler,
+				Subject:  cfg.ChangePasswordConfig.Subject,
+				Template: cfg.ChangePasswordConfig.Template,
+			},
+		}
+	}
+
+	routes := make([]events.Route, 0, len(cfg.Routes))
+	for _, r := range cfg.Routes {
+		routes = append(routes, events.Route{
+			Topic:    r.Topic,
+			Type:     r.Type,
+			Handler:  r.Handler,
+			Subject:  r.Subject,
+			Template: r.Template,
+		})
+	}
+	return routes
+}
+
+func valueOrDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
+
+func newMailSender(cfg *config.Config, logger *logrus.Logger) (service.MailSender, func(), error) {
+	switch strings.ToUpper(cfg.MailTransport) {
+	case "", "SMTP":
+		sender, err := email.NewMailSender(cfg.MailSenderCfg, logger)
+		if err != nil {
+			return nil, nil, err
+		}
+		return sender, sender.Shutdown, nil
+	case "HTTP":
+		sender, err := httpmail.NewMailSender(cfg.HTTPMailSenderCfg, logger)
+		if err != nil {
+			return nil, nil, err
+		}
+		return sender, func() {}, nil
+	case "FILE":
+		sender, err := email.NewFileSink(cfg.MailSinkCfg, logger)
+		if err != nil {
+			return nil, nil, err
+		}
+		return sender, func() {}, nil
+	case "MAILDIR":
+		sender, err := email.NewMaildirSink(cfg.MailSinkCfg, logger)
+		if err != nil {
+			return nil, nil, err
+		}
+		return sender, func() {}, nil
+	case "STDOUT":
+		return email.NewStdoutSink(cfg.MailSinkCfg, logger), func() {}, nil
 	}
+	return nil, nil, fmt.Errorf("unsupported mail transport %q", cfg.MailTransport)
 }
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Falokut/email_service/internal/config"
+	"github.com/Falokut/email_service/internal/events"
+)
+
+func TestGetRoutesFromLegacySections(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.OrderCreatedConfig.Subject = "Order"
+	cfg.OrderCreatedConfig.Template = "orderCreatedNotification.html"
+	cfg.EmailVerificationConfig.Topic = "accounts.verification"
+	cfg.EmailVerificationConfig.Subject = "Account activation"
+	cfg.EmailVerificationConfig.Template = "accountActivation.html"
+	cfg.ChangePasswordConfig.Subject = "Password change"
+	cfg.ChangePasswordConfig.Template = "changePassword.html"
+
+	want := []events.Route{
+		{Topic: defaultOrderCreatedTopic, Handler: events.OrderCreatedHandler, Subject: "Order", Template: "orderCreatedNotification.html"},
+		// topic of the section overrides the default one
+		{Topic: "accounts.verification", Handler: events.TokenHandler, Subject: "Account activation", Template: "accountActivation.html"},
+		{Topic: defaultChangePasswordTopic, Handler: events.TokenHandler, Subject: "Password change", Template: "changePassword.html"},
+	}
+	got := getRoutes(cfg)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got routes %+v, want %+v", got, want)
+	}
+	if _, err := events.NewRoutingTable(got); err != nil {
+		t.Errorf("routes of the legacy sections: %v", err)
+	}
+}
+
+func TestGetRoutesIgnoresLegacySections(t *testing.T) {
+	cfg := &config.Config{
+		Routes: []config.RouteConfig{
+			{Topic: "accounts", Type: "password_reset", Handler: "token", Subject: "Password", Template: "password.html"},
+		},
+	}
+	cfg.OrderCreatedConfig.Template = "orderCreatedNotification.html"
+
+	want := []events.Route{
+		{Topic: "accounts", Type: "password_reset", Handler: "token", Subject: "Password", Template: "password.html"},
+	}
+	if got := getRoutes(cfg); !reflect.DeepEqual(got, want) {
+		t.Errorf("got routes %+v, want %+v", got, want)
+	}
+}
@@ -8,6 +8,12 @@ import (
 	"time"
 
 	"github.com/Falokut/email_service/internal/email"
+	"github.com/Falokut/email_service/internal/health"
+	"github.com/Falokut/email_service/internal/httpmail"
+	"github.com/Falokut/email_service/internal/idempotency"
+	"github.com/Falokut/email_service/internal/metrics"
+	"github.com/Falokut/email_service/internal/tracing"
+	"github.com/Falokut/email_service/internal/wallet"
 	"github.com/Falokut/email_service/pkg/logging"
 	"github.com/ilyakaznacheev/cleanenv"
 	"google.golang.org/grpc"
@@ -16,14 +22,86 @@ import (
 )
 
 type KafkaReaderConfig struct {
-	Brokers          []string      `yaml:"brokers"`
-	GroupID          string        `yaml:"group_id"`
-	ReadBatchTimeout time.Duration `yaml:"read_batch_timeout"`
+	Brokers           []string        `yaml:"brokers"`
+	GroupID           string          `yaml:"group_id"`
+	ClientID          string          `yaml:"client_id"`
+	ReadBatchTimeout  time.Duration   `yaml:"read_batch_timeout"`
+	StartOffset       string          `yaml:"start_offset"`
+	MinBytes          int             `yaml:"min_bytes"`
+	MaxBytes          int             `yaml:"max_bytes"`
+	SessionTimeout    time.Duration   `yaml:"session_timeout"`
+	HeartbeatInterval time.Duration   `yaml:"heartbeat_interval"`
+	RebalanceStrategy string          `yaml:"rebalance_strategy"`
+	CommitInterval    time.Duration   `yaml:"commit_interval"`
+	SASL              KafkaSASLConfig `yaml:"sasl"`
+	TLS               BrokerTLSConfig `yaml:"tls"`
+	Retry             RetryConfig     `yaml:"retry"`
+	Pool              PoolConfig      `yaml:"pool"`
+}
+
+type KafkaSASLConfig struct {
+	Mechanism string `yaml:"mechanism"`
+	Username  string `yaml:"username"`
+	Password  string `yaml:"password"`
+}
+
+type BrokerTLSConfig struct {
+	Enabled            bool   `yaml:"enabled"`
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+type BrokerConfig struct {
+	// KAFKA, NATS, AMQP or MEMORY
+	Type string     `yaml:"type" env:"MESSAGE_BROKER"`
+	NATS NATSConfig `yaml:"nats"`
+	AMQP AMQPConfig `yaml:"amqp"`
+}
+
+type NATSConfig struct {
+	URL       string          `yaml:"url" env:"NATS_URL"`
+	Stream    string          `yaml:"stream" env:"NATS_STREAM"`
+	Username  string          `yaml:"username" env:"NATS_USERNAME"`
+	Password  string          `yaml:"password" env:"NATS_PASSWORD"`
+	Token     string          `yaml:"token" env:"NATS_TOKEN"`
+	CredsFile string          `yaml:"creds_file" env:"NATS_CREDS_FILE"`
+	AckWait   time.Duration   `yaml:"ack_wait"`
+	TLS       BrokerTLSConfig `yaml:"tls"`
+}
+
+type AMQPConfig struct {
+	URL      string          `yaml:"url" env:"AMQP_URL"`
+	Prefetch int             `yaml:"prefetch"`
+	TLS      BrokerTLSConfig `yaml:"tls"`
+}
+
+type PoolConfig struct {
+	Workers     int    `yaml:"workers"`
+	MaxInFlight int    `yaml:"max_in_flight"`
+	OrderBy     string `yaml:"order_by"`
+}
+
+type RetryConfig struct {
+	MaxAttempts    int           `yaml:"max_attempts"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	Multiplier     float64       `yaml:"multiplier"`
 }
 
 type Config struct {
-	LogLevel      string                 `yaml:"log_level" env:"LOG_LEVEL"`
-	MailSenderCfg email.MailSenderConfig `yaml:"mail_sender"`
+	LogLevel string `yaml:"log_level" env:"LOG_LEVEL"`
+	// text or json
+	LogFormat    string `yaml:"log_format" env:"LOG_FORMAT"`
+	TemplatesDir string `yaml:"templates_dir" env:"TEMPLATES_DIR" env-default:"templates"`
+	// SMTP, HTTP or one of the development transports FILE, MAILDIR, STDOUT
+	MailTransport     string                 `yaml:"mail_transport" env:"MAIL_TRANSPORT"`
+	MailSenderCfg     email.MailSenderConfig `yaml:"mail_sender"`
+	HTTPMailSenderCfg httpmail.Config        `yaml:"http_mail_sender"`
+	MailSinkCfg       email.SinkConfig       `yaml:"mail_sink"`
+	WalletPass        wallet.PassConfig      `yaml:"wallet_pass"`
 
 	CinemaServiceConfig struct {
 		Addr         string                 `yaml:"addr" env:"CINEMA_SERVICE_ADDRESS"`
@@ -35,25 +113,49 @@ type Config struct {
 		SecureConfig ConnectionSecureConfig `yaml:"secure_config"`
 	} `yaml:"movies_service_config"`
 
+	Healthcheck   health.Config      `yaml:"healthcheck"`
+	Tracing       tracing.Config     `yaml:"tracing"`
+	Deduplication idempotency.Config `yaml:"deduplication"`
+
+	PrometheusConfig struct {
+		ServerConfig metrics.ServerConfig `yaml:"server_config"`
+	} `yaml:"prometheus"`
+
+	Broker BrokerConfig `yaml:"broker"`
+
 	OrdersEventsConfig           KafkaReaderConfig `yaml:"orders_events"`
 	TokensDeliveryRequestsConfig KafkaReaderConfig `yaml:"tokens_delivery_requests"`
 
+	// if empty, routes are made from the email_verification, change_password and order_created sections
+	Routes []RouteConfig `yaml:"routes"`
+
 	EmailVerificationConfig struct {
+		Topic    string `yaml:"topic" env:"EMAIL_VERIFICATION_TOPIC"`
 		Subject  string `yaml:"subject" env:"EMAIL_VERIFICATION_SUBJECT"`
 		Template string `yaml:"template" env:"EMAIL_VERIFICATION_TEMPLATE"`
 	} `yaml:"email_verification"`
 
 	ChangePasswordConfig struct {
+		Topic    string `yaml:"topic" env:"CHANGE_PASSWORD_TOPIC"`
 		Subject  string `yaml:"subject" env:"CHANGE_PASSWORD_SUBJECT"`
 		Template string `yaml:"template" env:"CHANGE_PASSWORD_TEMPLATE"`
 	} `yaml:"change_password"`
 
 	OrderCreatedConfig struct {
+		Topic    string `yaml:"topic" env:"ORDER_CREATED_TOPIC"`
 		Subject  string `yaml:"subject" env:"ORDER_CREATED_SUBJECT"`
 		Template string `yaml:"template" env:"ORDER_CREATED_TEMPLATE"`
 	} `yaml:"order_created"`
 }
 
+type RouteConfig struct {
+	Topic    string `yaml:"topic"`
+	Type     string `yaml:"type"`
+	Handler  string `yaml:"handler"`
+	Subject  string `yaml:"subject"`
+	Template string `yaml:"template"`
+}
+
 const configsPath string = "configs/"
 
 var instance *Config
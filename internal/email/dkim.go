@@ -0,0 +1,139 @@
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+type DKIMConfig struct {
+	Enabled  bool   `yaml:"enabled" env:"DKIM_ENABLED"`
+	Domain   string `yaml:"domain" env:"DKIM_DOMAIN"`
+	Selector string `yaml:"selector" env:"DKIM_SELECTOR"`
+	// pem encoded rsa or ed25519 private key
+	KeyPath string `yaml:"key_path" env:"DKIM_KEY_PATH"`
+	// signed header fields, if empty recommended headers are signed
+	Headers []string `yaml:"headers" env:"DKIM_HEADERS"`
+	// simple or relaxed
+	HeaderCanonicalization string `yaml:"header_canonicalization" env:"DKIM_HEADER_CANONICALIZATION"`
+	BodyCanonicalization   string `yaml:"body_canonicalization" env:"DKIM_BODY_CANONICALIZATION"`
+}
+
+// recommended by RFC 6376 section 5.4.1
+var defaultDKIMHeaders = []string{
+	"From", "Reply-To", "Subject", "Date", "To", "Cc",
+	"Mime-Version", "Content-Type", "Content-Transfer-Encoding",
+}
+
+type dkimSigner struct {
+	options *dkim.SignOptions
+}
+
+func newDKIMSigner(cfg DKIMConfig) (*dkimSigner, error) {
+	if cfg.Domain == "" || cfg.Selector == "" {
+		return nil, errors.New("dkim: domain and selector must be specified")
+	}
+
+	key, err := loadDKIMKey(cfg.KeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	headerCanonicalization, err := parseCanonicalization(cfg.HeaderCanonicalization)
+	if err != nil {
+		return nil, err
+	}
+	bodyCanonicalization, err := parseCanonicalization(cfg.BodyCanonicalization)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := cfg.Headers
+	if len(headers) == 0 {
+		headers = defaultDKIMHeaders
+	}
+
+	return &dkimSigner{
+		options: &dkim.SignOptions{
+			Domain:                 cfg.Domain,
+			Selector:               cfg.Selector,
+			Signer:                 key,
+			HeaderCanonicalization: headerCanonicalization,
+			BodyCanonicalization:   bodyCanonicalization,
+			HeaderKeys:             headers,
+		},
+	}, nil
+}
+
+// Sign writes the message and prepends DKIM-Signature header to it.
+func (s *dkimSigner) Sign(msg io.WriterTo) (rawMessage, error) {
+	var unsigned bytes.Buffer
+	if _, err := msg.WriteTo(&unsigned); err != nil {
+		return nil, err
+	}
+
+	var signed bytes.Buffer
+	if err := dkim.Sign(&signed, &unsigned, s.options); err != nil {
+		return nil, err
+	}
+	return signed.Bytes(), nil
+}
+
+func parseCanonicalization(value string) (dkim.Canonicalization, error) {
+	switch strings.ToLower(value) {
+	case "", string(dkim.CanonicalizationSimple):
+		return dkim.CanonicalizationSimple, nil
+	case string(dkim.CanonicalizationRelaxed):
+		return dkim.CanonicalizationRelaxed, nil
+	}
+	return "", fmt.Errorf("dkim: unsupported canonicalization %q", value)
+}
+
+func loadDKIMKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("dkim: key isn't pem encoded")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		switch key := key.(type) {
+		case *rsa.PrivateKey:
+			return key, nil
+		case ed25519.PrivateKey:
+			return key, nil
+		}
+		return nil, fmt.Errorf("dkim: unsupported key type %T", key)
+	}
+	return nil, fmt.Errorf("dkim: unsupported pem block %q", block.Type)
+}
+
+// rawMessage is the already written message, it can be sent several times.
+type rawMessage []byte
+
+func (m rawMessage) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(m)
+	return int64(n), err
+}
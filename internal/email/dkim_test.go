@@ -0,0 +1,120 @@
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+// writeDKIMKey writes the pkcs8 pem encoded private key and returns the DKIM TXT record of its public key.
+func writeDKIMKey(t *testing.T, key crypto.Signer, keyType string) (path, record string) {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path = filepath.Join(t.TempDir(), "dkim.pem")
+	if err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var public []byte
+	switch pub := key.Public().(type) {
+	case ed25519.PublicKey:
+		public = pub
+	default:
+		if public, err = x509.MarshalPKIXPublicKey(pub); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path, fmt.Sprintf("v=DKIM1; k=%s; p=%s", keyType, base64.StdEncoding.EncodeToString(public))
+}
+
+func TestDKIMSignVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name             string
+		key              crypto.Signer
+		keyType          string
+		canonicalization string
+	}{
+		{name: "rsa simple", key: rsaKey, keyType: "rsa"},
+		{name: "rsa relaxed", key: rsaKey, keyType: "rsa", canonicalization: "relaxed"},
+		{name: "ed25519", key: ed25519Key, keyType: "ed25519", canonicalization: "relaxed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, record := writeDKIMKey(t, tt.key, tt.keyType)
+			signer, err := newDKIMSigner(DKIMConfig{
+				Domain:                 "cinema.local",
+				Selector:               "mail",
+				KeyPath:                path,
+				HeaderCanonicalization: tt.canonicalization,
+				BodyCanonicalization:   tt.canonicalization,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			m, err := newMessage("noreply@cinema.local", testMail("user@example.com"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			signed, err := signer.Sign(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var lookups []string
+			options := &dkim.VerifyOptions{LookupTXT: func(domain string) ([]string, error) {
+				lookups = append(lookups, domain)
+				return []string{record}, nil
+			}}
+			verifications, err := dkim.VerifyWithOptions(bytes.NewReader(signed), options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(verifications) != 1 {
+				t.Fatalf("%d signatures, want 1", len(verifications))
+			}
+			if err = verifications[0].Err; err != nil {
+				t.Fatalf("signature isn't verified: %v", err)
+			}
+			if verifications[0].Domain != "cinema.local" {
+				t.Errorf("signing domain %s, want cinema.local", verifications[0].Domain)
+			}
+			if len(lookups) != 1 || lookups[0] != "mail._domainkey.cinema.local" {
+				t.Errorf("public key looked up at %v, want mail._domainkey.cinema.local", lookups)
+			}
+
+			// tampered body fails the verification
+			tampered := bytes.Replace(signed, []byte("Your order is confirmed"), []byte("Your order is canceled!"), 1)
+			verifications, err = dkim.VerifyWithOptions(bytes.NewReader(tampered), options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(verifications) != 1 || verifications[0].Err == nil {
+				t.Error("signature of the tampered message is verified")
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"net/textproto"
+
+	"github.com/Falokut/email_service/internal/models"
+)
+
+// sendError converts an error occurred while sending a message into models.ServiceError.
+// Smtp 4xx replies and network failures are transient, 5xx replies to the mail transaction are permanent.
+func sendError(err error, dialing bool) error {
+	if err == nil {
+		return nil
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return models.Error(models.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return models.Error(models.DeadlineExceeded, err.Error())
+	}
+
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		// 5xx replies while connecting are caused by relay misconfiguration, not by the message
+		if protoErr.Code >= 500 && !dialing {
+			return models.Errorf(models.Rejected, "smtp: %s", err)
+		}
+		return models.Errorf(models.Unavailable, "smtp: %s", err)
+	}
+
+	if dialing || isBrokenConn(err) {
+		return models.Errorf(models.Unavailable, "smtp: %s", err)
+	}
+	return models.Errorf(models.Internal, "smtp: %s", err)
+}
@@ -2,16 +2,28 @@ package email
 
 import (
 	"context"
-	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"time"
 
+	"github.com/Falokut/email_service/internal/models"
+	"github.com/Falokut/email_service/internal/tracing"
+	"github.com/Falokut/email_service/pkg/logging"
 	"github.com/sirupsen/logrus"
-	"gopkg.in/gomail.v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("github.com/Falokut/email_service/internal/email")
+
 type MailSender struct {
 	logger       *logrus.Logger
-	dialler      *gomail.Dialer
+	relays       []*relay
 	emailAddress string
+	// nil if dkim signing disabled
+	dkim *dkimSigner
 }
 
 type MailSenderConfig struct {
@@ -21,38 +33,149 @@ type MailSenderConfig struct {
 	EmailAddress string `yaml:"email_address" env:"EMAIL_ADDRESS"`
 	EmailLogin   string `yaml:"email_login" env:"EMAIl_LOGIN"`
 	EnableTLS    bool   `yaml:"enable_TLS" env:"ENABLE_TLS"`
+
+	TLS  TLSConfig  `yaml:"tls"`
+	Pool PoolConfig `yaml:"pool"`
+	DKIM DKIMConfig `yaml:"dkim"`
+
+	// if not empty, mail is sent through these relays instead of the email_host
+	Relays   []RelayConfig  `yaml:"relays"`
+	Ejection EjectionConfig `yaml:"ejection"`
+}
+
+type PoolConfig struct {
+	// max number of simultaneously opened smtp connections
+	Size int `yaml:"size" env:"EMAIL_POOL_SIZE"`
+	// idle connections are closed after this timeout
+	IdleTimeout time.Duration `yaml:"idle_timeout" env:"EMAIL_POOL_IDLE_TIMEOUT"`
+	// connection is closed after sending this number of messages
+	MaxMessagesPerConn int `yaml:"max_messages_per_conn" env:"EMAIL_POOL_MAX_MESSAGES_PER_CONN"`
 }
 
-func NewMailSender(cfg MailSenderConfig, logger *logrus.Logger) *MailSender {
+const (
+	defaultPoolSize           = 4
+	defaultPoolIdleTimeout    = 30 * time.Second
+	defaultMaxMessagesPerConn = 100
+)
+
+func NewMailSender(cfg MailSenderConfig, logger *logrus.Logger) (*MailSender, error) {
 	s := MailSender{logger: logger, emailAddress: cfg.EmailAddress}
+	if cfg.DKIM.Enabled {
+		signer, err := newDKIMSigner(cfg.DKIM)
+		if err != nil {
+			return nil, err
+		}
+		s.dkim = signer
+	}
 
 	s.logger.Infoln("Creating mail dialler.")
-	s.dialler = gomail.NewDialer(cfg.Host, cfg.Port, cfg.EmailLogin, cfg.Password)
-	s.dialler.TLSConfig = &tls.Config{InsecureSkipVerify: true}
-	return &s
+	relays := cfg.Relays
+	if len(relays) == 0 {
+		relays = []RelayConfig{{
+			Host:      cfg.Host,
+			Port:      cfg.Port,
+			Login:     cfg.EmailLogin,
+			Password:  cfg.Password,
+			EnableTLS: cfg.EnableTLS,
+			TLS:       cfg.TLS,
+			Pool:      cfg.Pool,
+		}}
+	}
+
+	if cfg.Ejection.MaxFailures <= 0 {
+		cfg.Ejection.MaxFailures = defaultEjectionMaxFailures
+	}
+	if cfg.Ejection.Duration <= 0 {
+		cfg.Ejection.Duration = defaultEjectionDuration
+	}
+	for _, relayCfg := range relays {
+		r, err := newRelay(relayCfg, cfg.Ejection, logger)
+		if err != nil {
+			s.Shutdown()
+			return nil, err
+		}
+		s.relays = append(s.relays, r)
+	}
+	return &s, nil
 }
 
-func (s *MailSender) SendEmail(ctx context.Context, email string, subject string, emailBody, altBody string) error {
-	sender, err := s.dialler.Dial()
+func (s *MailSender) Shutdown() {
+	for _, r := range s.relays {
+		r.pool.Close()
+	}
+}
+
+// Ping checks that at least one of the relays is reachable.
+func (s *MailSender) Ping(ctx context.Context) error {
+	var errs []error
+	for _, r := range s.relays {
+		err := r.ping(ctx)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("smtp relay %s: %w", r.name, err))
+	}
+	return errors.Join(errs...)
+}
+
+func (s *MailSender) SendEmail(ctx context.Context, mail models.Mail) error {
+	logger := logging.FromContext(ctx, s.logger)
+	logger.Infoln("Creating message.")
+	m, err := newMessage(s.emailAddress, mail)
 	if err != nil {
-		s.logger.Error(err)
-		return err
+		logger.Error(err.Error())
+		return models.Errorf(models.Internal, "can't create message: %s", err)
 	}
-	defer sender.Close()
 
-	s.logger.Infoln("Creating message.")
-	m := gomail.NewMessage()
-	m.SetHeader("From", s.emailAddress)
-	m.SetHeader("Subject", subject)
-	m.AddAlternative("text/plain", altBody)
-	m.SetBody("text/html", emailBody)
+	var msg io.WriterTo = m
+	if s.dkim != nil {
+		if msg, err = s.dkim.Sign(m); err != nil {
+			logger.Error(err.Error())
+			return models.Errorf(models.Internal, "can't sign message: %s", err)
+		}
+	}
 
-	s.logger.Infoln("Sending message.")
-	if err := sender.Send(s.emailAddress, []string{email}, m); err != nil {
-		s.logger.Error(err.Error())
-		return nil
+	logger.Infoln("Sending message.")
+	ctx, span := tracer.Start(ctx, "smtp send", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.Int("mail.recipients", len(mail.Recipients()))))
+	err = s.send(ctx, mail.Recipients(), msg)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		logger.Error(err.Error())
+		return err
 	}
 
-	s.logger.Infoln("Message sended.")
+	logger.Infoln("Message sended.")
+	return nil
+}
+
+// send sends the message to each recipients group through the relays routed for the group.
+// On failure of the group the error is returned, groups sent before it get the message again on retry.
+func (s *MailSender) send(ctx context.Context, to []string, m io.WriterTo) error {
+	if len(to) == 0 {
+		return models.Error(models.InvalidArgument, "mail has no recipients")
+	}
+	for _, group := range groupRecipients(s.relays, to) {
+		if err := s.sendGroup(ctx, group, m); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// sendGroup tries relays one by one until message is sent or rejected.
+func (s *MailSender) sendGroup(ctx context.Context, group recipientGroup, m io.WriterTo) (err error) {
+	for _, r := range route(s.relays, group.domain) {
+		err = r.send(ctx, s.emailAddress, group.to, m)
+		r.report(ctx, err)
+		if err == nil || models.IsPermanent(err) || ctx.Err() != nil {
+			return err
+		}
+		logging.FromContext(ctx, s.logger).Warnf("sending through smtp relay %s failed, trying next relay: %v", r.name, err)
+	}
+
+	if err == nil {
+		err = models.Error(models.Unavailable, "no smtp relays for the recipient")
+	}
+	return err
+}
@@ -0,0 +1,76 @@
+package email
+
+import (
+	"io"
+
+	"github.com/Falokut/email_service/internal/models"
+	"gopkg.in/gomail.v2"
+)
+
+func newMessage(from string, mail models.Mail) (*gomail.Message, error) {
+	m := gomail.NewMessage()
+	m.SetHeader("From", from)
+	for k, v := range mail.Headers {
+		m.SetHeader(k, v)
+	}
+	if len(mail.To) > 0 {
+		m.SetHeader("To", mail.To...)
+	}
+	if len(mail.Cc) > 0 {
+		m.SetHeader("Cc", mail.Cc...)
+	}
+	// bcc header isn't written into the message, only used for the envelope
+	if len(mail.Bcc) > 0 {
+		m.SetHeader("Bcc", mail.Bcc...)
+	}
+	if mail.ReplyTo != "" {
+		m.SetHeader("Reply-To", mail.ReplyTo)
+	}
+	m.SetHeader("Subject", mail.Subject)
+
+	switch {
+	case mail.TextBody != "" && mail.HTMLBody != "":
+		m.SetBody("text/plain", mail.TextBody)
+		m.AddAlternative("text/html", mail.HTMLBody)
+	case mail.HTMLBody != "":
+		m.SetBody("text/html", mail.HTMLBody)
+	default:
+		m.SetBody("text/plain", mail.TextBody)
+	}
+
+	for _, part := range mail.Inline {
+		content, err := part.ReadContent()
+		if err != nil {
+			return nil, err
+		}
+
+		m.Embed(part.Name, copyContent(content), gomail.SetHeader(map[string][]string{
+			"Content-ID":   {"<" + part.ContentID + ">"},
+			"Content-Type": {part.ContentType},
+		}))
+	}
+
+	for _, attachment := range mail.Attachments {
+		content, err := attachment.ReadContent()
+		if err != nil {
+			return nil, err
+		}
+
+		settings := []gomail.FileSetting{copyContent(content)}
+		if attachment.ContentType != "" {
+			settings = append(settings, gomail.SetHeader(map[string][]string{
+				"Content-Type": {attachment.ContentType},
+			}))
+		}
+		m.Attach(attachment.Name, settings...)
+	}
+
+	return m, nil
+}
+
+func copyContent(content []byte) gomail.FileSetting {
+	return gomail.SetCopyFunc(func(w io.Writer) error {
+		_, err := w.Write(content)
+		return err
+	})
+}
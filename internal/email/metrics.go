@@ -0,0 +1,51 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/textproto"
+	"time"
+
+	"github.com/Falokut/email_service/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	sendDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "smtp",
+		Name:      "send_duration_seconds",
+		Help:      "Duration of sending message through the smtp relay, including connecting.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"relay"})
+
+	sendResults = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "smtp",
+		Name:      "send_results_total",
+		Help:      "Number of messages sent through the smtp relay by the reply status class: 2xx, 4xx, 5xx, error or canceled.",
+	}, []string{"relay", "status_class"})
+)
+
+func observeSend(relay string, duration time.Duration, err error) {
+	sendDuration.WithLabelValues(relay).Observe(duration.Seconds())
+	sendResults.WithLabelValues(relay, statusClass(err)).Inc()
+}
+
+// statusClass returns the class of the smtp reply, which caused the error.
+func statusClass(err error) string {
+	if err == nil {
+		return "2xx"
+	}
+
+	var protoErr *textproto.Error
+	switch {
+	case errors.As(err, &protoErr):
+		return fmt.Sprintf("%dxx", protoErr.Code/100)
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "canceled"
+	}
+	return "error"
+}
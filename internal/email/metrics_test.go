@@ -0,0 +1,66 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestStatusClass(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "sent", want: "2xx"},
+		{name: "transient", err: &textproto.Error{Code: 451, Msg: "try again later"}, want: "4xx"},
+		{name: "rejected", err: fmt.Errorf("rcpt: %w", &textproto.Error{Code: 550, Msg: "no such user"}), want: "5xx"},
+		{name: "canceled", err: fmt.Errorf("dial: %w", context.Canceled), want: "canceled"},
+		{name: "timeout", err: context.DeadlineExceeded, want: "canceled"},
+		{name: "network", err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, want: "error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := statusClass(tt.err); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendMetrics(t *testing.T) {
+	sendResults.Reset()
+	sendDuration.Reset()
+	server := newFakeSMTP(t)
+	cfg := server.relayConfig()
+	cfg.Name = "primary"
+	sender := newTestMailSender(t, cfg)
+
+	if err := sender.SendEmail(context.Background(), testMail("user@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	server.set(func(s *fakeSMTP) { s.mailReply = "550 mailbox unavailable" })
+	if err := sender.SendEmail(context.Background(), testMail("user@example.com")); err == nil {
+		t.Fatal("rejected mail is sent")
+	}
+
+	want := `
+# HELP email_service_smtp_send_results_total Number of messages sent through the smtp relay by the reply status class: 2xx, 4xx, 5xx, error or canceled.
+# TYPE email_service_smtp_send_results_total counter
+email_service_smtp_send_results_total{relay="primary",status_class="2xx"} 1
+email_service_smtp_send_results_total{relay="primary",status_class="5xx"} 1
+`
+	if err := testutil.CollectAndCompare(sendResults, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+	// duration isn't predictable, so only the series are counted
+	if got := testutil.CollectAndCount(sendDuration); got != 1 {
+		t.Errorf("got %d duration series, want 1", got)
+	}
+}
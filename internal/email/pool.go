@@ -0,0 +1,145 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var errPoolClosed = errors.New("smtp connections pool closed")
+
+// connPool is a bounded pool of reusable smtp sessions.
+type connPool struct {
+	dial        func(ctx context.Context) (*smtpConn, error)
+	idleTimeout time.Duration
+	maxMessages int
+
+	// limits the number of simultaneously opened sessions
+	sem chan struct{}
+
+	mu     sync.Mutex
+	idle   []*smtpConn
+	closed bool
+	done   chan struct{}
+}
+
+func newConnPool(dial func(ctx context.Context) (*smtpConn, error),
+	size int, idleTimeout time.Duration, maxMessages int) *connPool {
+	p := &connPool{
+		dial:        dial,
+		idleTimeout: idleTimeout,
+		maxMessages: maxMessages,
+		sem:         make(chan struct{}, size),
+		done:        make(chan struct{}),
+	}
+
+	go p.reapIdle()
+	return p
+}
+
+// Get returns an idle session or dials a new one.
+// Returned session must be given back to the pool with Put.
+func (p *connPool) Get(ctx context.Context) (conn *smtpConn, reused bool, err error) {
+	select {
+	case p.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	case <-p.done:
+		return nil, false, errPoolClosed
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		<-p.sem
+		return nil, false, errPoolClosed
+	}
+	for len(p.idle) > 0 {
+		conn = p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if time.Since(conn.lastUsed) < p.idleTimeout {
+			break
+		}
+		go conn.Quit()
+		conn = nil
+	}
+	p.mu.Unlock()
+
+	if conn != nil {
+		return conn, true, nil
+	}
+
+	conn, err = p.dial(ctx)
+	if err != nil {
+		<-p.sem
+		return nil, false, err
+	}
+	return conn, false, nil
+}
+
+// Put gives the session back to the pool. Broken or worn out sessions are closed.
+func (p *connPool) Put(conn *smtpConn, broken bool) {
+	defer func() { <-p.sem }()
+
+	if broken {
+		conn.Close()
+		return
+	}
+
+	p.mu.Lock()
+	if p.closed || conn.sent >= p.maxMessages {
+		p.mu.Unlock()
+		conn.Quit()
+		return
+	}
+	p.idle = append(p.idle, conn)
+	p.mu.Unlock()
+}
+
+func (p *connPool) reapIdle() {
+	ticker := time.NewTicker(p.idleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+
+		p.mu.Lock()
+		alive := p.idle[:0]
+		var expired []*smtpConn
+		for _, conn := range p.idle {
+			if time.Since(conn.lastUsed) >= p.idleTimeout {
+				expired = append(expired, conn)
+				continue
+			}
+			alive = append(alive, conn)
+		}
+		p.idle = alive
+		p.mu.Unlock()
+
+		for _, conn := range expired {
+			conn.Quit()
+		}
+	}
+}
+
+// Close closes all idle sessions, sessions in use will be closed on Put.
+func (p *connPool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.done)
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+
+	for _, conn := range idle {
+		conn.Quit()
+	}
+}
@@ -0,0 +1,131 @@
+package email
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func sendTestMail(t *testing.T, sender *MailSender) {
+	t.Helper()
+	if err := sender.SendEmail(context.Background(), testMail("user@example.com")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPoolReusesConnection(t *testing.T) {
+	server := newFakeSMTP(t)
+	sender := newTestMailSender(t, server.relayConfig())
+
+	for i := 0; i < 3; i++ {
+		sendTestMail(t, sender)
+	}
+
+	conns, _, sent := server.stats()
+	if len(sent) != 3 {
+		t.Fatalf("%d messages sent, want 3", len(sent))
+	}
+	if conns != 1 {
+		t.Errorf("%d connections opened, want 1", conns)
+	}
+}
+
+func TestPoolReconnectsBrokenConnection(t *testing.T) {
+	tests := []struct {
+		name      string
+		breakConn func(s *fakeSMTP)
+	}{
+		{name: "421 reply", breakConn: func(s *fakeSMTP) { s.busyAfter = 1 }},
+		{name: "dropped connection", breakConn: func(s *fakeSMTP) { s.dropAfter = 1 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeSMTP(t)
+			server.set(tt.breakConn)
+			sender := newTestMailSender(t, server.relayConfig())
+
+			for i := 0; i < 2; i++ {
+				sendTestMail(t, sender)
+			}
+
+			conns, _, sent := server.stats()
+			if len(sent) != 2 {
+				t.Fatalf("%d messages sent, want 2", len(sent))
+			}
+			if conns != 2 {
+				t.Errorf("%d connections opened, want 2", conns)
+			}
+		})
+	}
+}
+
+func TestPoolReapsIdleConnections(t *testing.T) {
+	const idleTimeout = 50 * time.Millisecond
+	server := newFakeSMTP(t)
+	cfg := server.relayConfig()
+	cfg.Pool.IdleTimeout = idleTimeout
+	sender := newTestMailSender(t, cfg)
+
+	sendTestMail(t, sender)
+
+	pool := sender.relays[0].pool
+	deadline := time.Now().Add(time.Second)
+	for {
+		pool.mu.Lock()
+		idle := len(pool.idle)
+		pool.mu.Unlock()
+		_, quits, _ := server.stats()
+		if idle == 0 && quits == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d idle connections, %d sessions quit, want the idle connection closed", idle, quits)
+		}
+		time.Sleep(idleTimeout / 5)
+	}
+
+	// next message is sent through the new connection
+	sendTestMail(t, sender)
+	if conns, _, _ := server.stats(); conns != 2 {
+		t.Errorf("%d connections opened, want 2", conns)
+	}
+}
+
+func TestDialAuthenticates(t *testing.T) {
+	tests := []struct {
+		name       string
+		mechanisms string
+		wantErr    bool
+	}{
+		{name: "plain", mechanisms: "PLAIN LOGIN"},
+		// credentials aren't silently dropped when the server doesn't support AUTH
+		{name: "no auth", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeSMTP(t)
+			server.set(func(s *fakeSMTP) { s.authMechanisms = tt.mechanisms })
+			cfg := server.relayConfig()
+			cfg.Login, cfg.Password = "login", "password"
+			sender := newTestMailSender(t, cfg)
+
+			err := sender.SendEmail(context.Background(), testMail("user@example.com"))
+			_, _, sent := server.stats()
+			if tt.wantErr {
+				if err == nil || len(sent) != 0 {
+					t.Fatalf("error %v, %d messages sent, want the auth error", err, len(sent))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			server.mu.Lock()
+			user := server.authUser
+			server.mu.Unlock()
+			if len(sent) != 1 || user != "login" {
+				t.Errorf("%d messages sent as %q, want one sent as login", len(sent), user)
+			}
+		})
+	}
+}
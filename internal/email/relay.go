@@ -0,0 +1,300 @@
+package email
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Falokut/email_service/internal/models"
+	"github.com/Falokut/email_service/pkg/logging"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type RelayConfig struct {
+	Name      string `yaml:"name"`
+	Host      string `yaml:"host"`
+	Port      int    `yaml:"port"`
+	Login     string `yaml:"login"`
+	Password  string `yaml:"password"`
+	EnableTLS bool   `yaml:"enable_TLS"`
+
+	TLS  TLSConfig  `yaml:"tls"`
+	Pool PoolConfig `yaml:"pool"`
+
+	// relays with lower priority are used only when relays with higher priority (lower value) are unavailable
+	Priority int `yaml:"priority"`
+	// share of messages among relays with the same priority
+	Weight int `yaml:"weight"`
+	// if not empty, relay is used only for the recipients from these domains
+	Domains []string `yaml:"domains"`
+}
+
+type EjectionConfig struct {
+	// number of consecutive failures after which relay is ejected
+	MaxFailures int `yaml:"max_failures"`
+	// how long relay stays ejected
+	Duration time.Duration `yaml:"duration"`
+}
+
+const (
+	defaultEjectionMaxFailures = 3
+	defaultEjectionDuration    = 30 * time.Second
+)
+
+type relay struct {
+	name     string
+	priority int
+	weight   int
+	domains  map[string]struct{}
+	pool     *connPool
+	logger   *logrus.Logger
+
+	ejection     EjectionConfig
+	mu           sync.Mutex
+	failures     int
+	ejectedUntil time.Time
+	// last time relay accepted the transaction
+	lastSuccess time.Time
+}
+
+func newRelay(cfg RelayConfig, ejection EjectionConfig, logger *logrus.Logger) (*relay, error) {
+	mode, err := tlsMode(cfg.TLS, cfg.Port, cfg.EnableTLS)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := cfg.TLS.clientConfig(cfg.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &smtpDialer{
+		host:      cfg.Host,
+		port:      cfg.Port,
+		username:  cfg.Login,
+		password:  cfg.Password,
+		tlsMode:   mode,
+		tlsConfig: tlsConfig,
+	}
+
+	if cfg.Pool.Size <= 0 {
+		cfg.Pool.Size = defaultPoolSize
+	}
+	if cfg.Pool.IdleTimeout <= 0 {
+		cfg.Pool.IdleTimeout = defaultPoolIdleTimeout
+	}
+	if cfg.Pool.MaxMessagesPerConn <= 0 {
+		cfg.Pool.MaxMessagesPerConn = defaultMaxMessagesPerConn
+	}
+	if cfg.Weight <= 0 {
+		cfg.Weight = 1
+	}
+	if cfg.Name == "" {
+		cfg.Name = cfg.Host
+	}
+
+	domains := make(map[string]struct{}, len(cfg.Domains))
+	for _, domain := range cfg.Domains {
+		domains[strings.ToLower(domain)] = struct{}{}
+	}
+
+	return &relay{
+		name:     cfg.Name,
+		priority: cfg.Priority,
+		weight:   cfg.Weight,
+		domains:  domains,
+		pool:     newConnPool(dialer.Dial, cfg.Pool.Size, cfg.Pool.IdleTimeout, cfg.Pool.MaxMessagesPerConn),
+		logger:   logger,
+		ejection: ejection,
+	}, nil
+}
+
+func (r *relay) send(ctx context.Context, from string, to []string, m io.WriterTo) error {
+	start := time.Now()
+	dialing, err := r.deliver(ctx, from, to, m)
+	observeSend(r.name, time.Since(start), err)
+	trace.SpanFromContext(ctx).AddEvent("relay attempt", trace.WithAttributes(
+		attribute.String("smtp.relay", r.name),
+		attribute.String("smtp.status_class", statusClass(err)),
+	))
+	return sendError(err, dialing)
+}
+
+// deliver sends the message through the pooled connection, dialing is true if the error occurred while connecting.
+func (r *relay) deliver(ctx context.Context, from string, to []string, m io.WriterTo) (dialing bool, err error) {
+	conn, reused, err := r.pool.Get(ctx)
+	if err != nil {
+		return true, err
+	}
+
+	err = conn.Send(from, to, m)
+	if err != nil && reused && isBrokenConn(err) {
+		// pooled connection may be closed by server, so reconnecting once
+		logging.FromContext(ctx, r.logger).Debugf("smtp connection to %s is broken, reconnecting: %v", r.name, err)
+		r.pool.Put(conn, true)
+		if conn, _, err = r.pool.Get(ctx); err != nil {
+			return true, err
+		}
+		err = conn.Send(from, to, m)
+	}
+
+	if err != nil && !isBrokenConn(err) {
+		// server rejected the transaction, but session is still usable
+		r.pool.Put(conn, conn.Reset() != nil)
+		return false, err
+	}
+
+	r.pool.Put(conn, err != nil)
+	return false, err
+}
+
+func (r *relay) healthy() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return time.Now().After(r.ejectedUntil)
+}
+
+// report updates relay health, relay is ejected after several consecutive transient failures.
+// Cancelled or timed out ctx isn't the relay failure, so it's ignored.
+func (r *relay) report(ctx context.Context, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err == nil || models.IsPermanent(err) {
+		r.failures = 0
+		r.lastSuccess = time.Now()
+		return
+	}
+
+	r.failures++
+	if r.failures >= r.ejection.MaxFailures {
+		r.failures = 0
+		r.ejectedUntil = time.Now().Add(r.ejection.Duration)
+		logging.FromContext(ctx, r.logger).Warnf("smtp relay %s ejected until %s", r.name, r.ejectedUntil.Format(time.RFC3339))
+	}
+}
+
+// relay which recently accepted the transaction isn't probed
+const pingFreshness = 30 * time.Second
+
+// ping checks that relay is reachable with the NOOP command.
+func (r *relay) ping(ctx context.Context) error {
+	r.mu.Lock()
+	fresh := time.Since(r.lastSuccess) < pingFreshness
+	r.mu.Unlock()
+	if fresh {
+		return nil
+	}
+
+	conn, _, err := r.pool.Get(ctx)
+	if err != nil {
+		return err
+	}
+	err = conn.Noop()
+	r.pool.Put(conn, err != nil)
+	return err
+}
+
+func (r *relay) serves(domain string) bool {
+	_, ok := r.domains[domain]
+	return ok
+}
+
+// route returns relays in the order in which they should be tried for the recipient domain.
+// Relays dedicated to the domain go first, relays of the same priority are shuffled by weight,
+// ejected relays go last.
+func route(relays []*relay, domain string) []*relay {
+	var dedicated, common []*relay
+	for _, r := range relays {
+		switch {
+		case r.serves(domain):
+			dedicated = append(dedicated, r)
+		case len(r.domains) == 0:
+			common = append(common, r)
+		}
+	}
+
+	candidates := append(weightedOrder(dedicated), weightedOrder(common)...)
+	healthy := make([]*relay, 0, len(candidates))
+	var ejected []*relay
+	for _, r := range candidates {
+		if r.healthy() {
+			healthy = append(healthy, r)
+		} else {
+			ejected = append(ejected, r)
+		}
+	}
+	return append(healthy, ejected...)
+}
+
+func weightedOrder(relays []*relay) []*relay {
+	// weighted random shuffle, key = ln(u)/weight, where u is uniform on (0, 1)
+	keys := make(map[*relay]float64, len(relays))
+	for _, r := range relays {
+		keys[r] = -rand.ExpFloat64() / float64(r.weight)
+	}
+
+	ordered := append([]*relay(nil), relays...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		if ordered[i].priority != ordered[j].priority {
+			return ordered[i].priority < ordered[j].priority
+		}
+		return keys[ordered[i]] > keys[ordered[j]]
+	})
+	return ordered
+}
+
+// recipientGroup is the recipients which are sent the message in one transaction.
+type recipientGroup struct {
+	// recipients domain, empty for the recipients without dedicated relays
+	domain string
+	to     []string
+}
+
+// groupRecipients groups recipients by domain, so each group is routed to the relays dedicated to its domain.
+// Recipients of domains without dedicated relays are kept in one group.
+func groupRecipients(relays []*relay, to []string) []recipientGroup {
+	var groups []recipientGroup
+	index := make(map[string]int)
+	for _, addr := range to {
+		domain := recipientDomain(addr)
+		if !dedicated(relays, domain) {
+			domain = ""
+		}
+
+		i, ok := index[domain]
+		if !ok {
+			i = len(groups)
+			index[domain] = i
+			groups = append(groups, recipientGroup{domain: domain})
+		}
+		groups[i].to = append(groups[i].to, addr)
+	}
+	return groups
+}
+
+func dedicated(relays []*relay, domain string) bool {
+	for _, r := range relays {
+		if r.serves(domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func recipientDomain(addr string) string {
+	at := strings.LastIndex(addr, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSuffix(addr[at+1:], ">"))
+}
@@ -0,0 +1,114 @@
+package email
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/Falokut/email_service/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+func newTestMailSender(t *testing.T, relays ...RelayConfig) *MailSender {
+	t.Helper()
+	sender, err := NewMailSender(MailSenderConfig{
+		EmailAddress: "noreply@cinema.local",
+		Relays:       relays,
+		Ejection:     EjectionConfig{MaxFailures: 1},
+	}, logrus.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(sender.Shutdown)
+	return sender
+}
+
+func testMail(to ...string) models.Mail {
+	return models.Mail{To: to, Subject: "Order", TextBody: "Your order is confirmed"}
+}
+
+func TestMailSenderFailover(t *testing.T) {
+	primary, backup := newFakeSMTP(t), newFakeSMTP(t)
+	primary.set(func(s *fakeSMTP) { s.mailReply = "451 try again later" })
+
+	primaryCfg, backupCfg := primary.relayConfig(), backup.relayConfig()
+	backupCfg.Priority = 1
+	sender := newTestMailSender(t, primaryCfg, backupCfg)
+
+	if err := sender.SendEmail(context.Background(), testMail("user@example.com")); err != nil {
+		t.Fatalf("mail isn't sent through the backup relay: %v", err)
+	}
+	if _, _, sent := primary.stats(); len(sent) != 0 {
+		t.Errorf("%d messages sent through the failing relay", len(sent))
+	}
+	_, _, sent := backup.stats()
+	if len(sent) != 1 || !reflect.DeepEqual(sent[0].to, []string{"user@example.com"}) {
+		t.Fatalf("backup relay transactions %+v, want one to user@example.com", sent)
+	}
+
+	// failed relay is ejected, so the backup is tried first
+	if first := route(sender.relays, "")[0]; first.name != backupCfg.Name {
+		t.Errorf("relay %s is tried first, want the backup relay %s", first.name, backupCfg.Name)
+	}
+}
+
+func TestMailSenderRejectedNotFailedOver(t *testing.T) {
+	primary, backup := newFakeSMTP(t), newFakeSMTP(t)
+	primary.set(func(s *fakeSMTP) { s.mailReply = "550 mailbox unavailable" })
+
+	primaryCfg, backupCfg := primary.relayConfig(), backup.relayConfig()
+	backupCfg.Priority = 1
+	sender := newTestMailSender(t, primaryCfg, backupCfg)
+
+	err := sender.SendEmail(context.Background(), testMail("user@example.com"))
+	if !models.IsPermanent(err) {
+		t.Fatalf("error %v, want the permanent rejection", err)
+	}
+	if _, _, sent := backup.stats(); len(sent) != 0 {
+		t.Errorf("rejected mail is sent through the backup relay")
+	}
+	if !sender.relays[0].healthy() {
+		t.Error("relay is ejected for the rejected mail")
+	}
+}
+
+func TestRelayReportIgnoresCanceledContext(t *testing.T) {
+	server := newFakeSMTP(t)
+	sender := newTestMailSender(t, server.relayConfig())
+	r := sender.relays[0]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r.report(ctx, models.Error(models.Canceled, context.Canceled.Error()))
+	if !r.healthy() {
+		t.Fatal("relay is ejected after the canceled send")
+	}
+
+	r.report(context.Background(), models.Error(models.Unavailable, "smtp: 451 try again later"))
+	if r.healthy() {
+		t.Fatal("relay isn't ejected after the transient failure")
+	}
+}
+
+func TestMailSenderGroupsRecipientsByDomain(t *testing.T) {
+	dedicated, common := newFakeSMTP(t), newFakeSMTP(t)
+	dedicatedCfg := dedicated.relayConfig()
+	dedicatedCfg.Domains = []string{"example.org"}
+	sender := newTestMailSender(t, dedicatedCfg, common.relayConfig())
+
+	mail := testMail("first@example.org", "user@example.com")
+	mail.Cc = []string{"second@Example.org"}
+	mail.Bcc = []string{"other@example.net"}
+	if err := sender.SendEmail(context.Background(), mail); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, sent := dedicated.stats()
+	if len(sent) != 1 || !reflect.DeepEqual(sent[0].to, []string{"first@example.org", "second@Example.org"}) {
+		t.Errorf("dedicated relay transactions %+v, want one to the example.org recipients", sent)
+	}
+	_, _, sent = common.stats()
+	if len(sent) != 1 || !reflect.DeepEqual(sent[0].to, []string{"user@example.com", "other@example.net"}) {
+		t.Errorf("common relay transactions %+v, want one to the other recipients", sent)
+	}
+}
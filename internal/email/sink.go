@@ -0,0 +1,128 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Falokut/email_service/internal/models"
+	"github.com/Falokut/email_service/pkg/logging"
+	"github.com/sirupsen/logrus"
+)
+
+// SinkConfig configures development transports, which write rendered messages locally instead of sending them.
+type SinkConfig struct {
+	EmailAddress string `yaml:"email_address" env:"MAIL_SINK_EMAIL_ADDRESS"`
+	// directory for the FILE and MAILDIR sinks
+	Dir string `yaml:"dir" env:"MAIL_SINK_DIR"`
+}
+
+// Sink is the MailSender which writes each message in the RFC 5322 format
+// into the .eml file, maildir or stdout.
+type Sink struct {
+	logger       *logrus.Logger
+	emailAddress string
+	write        func(msg []byte) error
+}
+
+func newSink(cfg SinkConfig, logger *logrus.Logger, write func(msg []byte) error) *Sink {
+	return &Sink{logger: logger, emailAddress: cfg.EmailAddress, write: write}
+}
+
+// NewFileSink creates sink, which writes each message into the separate .eml file in the cfg.Dir.
+func NewFileSink(cfg SinkConfig, logger *logrus.Logger) (*Sink, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("mail sink dir must be specified")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return newSink(cfg, logger, func(msg []byte) error {
+		name := filepath.Join(cfg.Dir, uniqueName()+".eml")
+		logger.Debugf("writing message into the %s", name)
+		return os.WriteFile(name, msg, 0o644)
+	}), nil
+}
+
+// NewMaildirSink creates sink, which delivers messages into the maildir in the cfg.Dir.
+func NewMaildirSink(cfg SinkConfig, logger *logrus.Logger) (*Sink, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("mail sink dir must be specified")
+	}
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(cfg.Dir, sub), 0o755); err != nil {
+			return nil, err
+		}
+	}
+
+	return newSink(cfg, logger, func(msg []byte) error {
+		// message is written into the tmp and then moved into the new,
+		// so readers never see partially written messages
+		name := uniqueName()
+		tmp := filepath.Join(cfg.Dir, "tmp", name)
+		if err := os.WriteFile(tmp, msg, 0o644); err != nil {
+			return err
+		}
+		logger.Debugf("delivering message %s into the maildir", name)
+		return os.Rename(tmp, filepath.Join(cfg.Dir, "new", name))
+	}), nil
+}
+
+// NewStdoutSink creates sink, which prints messages to the stdout separated by the dashed line.
+func NewStdoutSink(cfg SinkConfig, logger *logrus.Logger) *Sink {
+	return newWriterSink(cfg, logger, os.Stdout)
+}
+
+func newWriterSink(cfg SinkConfig, logger *logrus.Logger, out io.Writer) *Sink {
+	var mu sync.Mutex
+	return newSink(cfg, logger, func(msg []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		_, err := fmt.Fprintf(out, "%s\n%s\n\n", strings.Repeat("-", 72), bytes.TrimRight(msg, "\r\n"))
+		return err
+	})
+}
+
+func (s *Sink) SendEmail(ctx context.Context, mail models.Mail) error {
+	logger := logging.FromContext(ctx, s.logger)
+	logger.Infoln("Creating message.")
+	m, err := newMessage(s.emailAddress, mail)
+	if err != nil {
+		logger.Error(err.Error())
+		return models.Errorf(models.Internal, "can't create message: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err = m.WriteTo(&buf); err != nil {
+		logger.Error(err.Error())
+		return models.Errorf(models.Internal, "can't create message: %s", err)
+	}
+
+	if err = s.write(buf.Bytes()); err != nil {
+		logger.Error(err.Error())
+		return models.Errorf(models.Internal, "can't write message: %s", err)
+	}
+
+	logger.Infof("Message for %s written.", strings.Join(mail.Recipients(), ", "))
+	return nil
+}
+
+var sinkDeliveries atomic.Int64
+
+// uniqueName makes the maildir compatible unique file name.
+func uniqueName() string {
+	hostname, _ := os.Hostname()
+	hostname = strings.NewReplacer("/", `\057`, ":", `\072`).Replace(hostname)
+	now := time.Now()
+	return fmt.Sprintf("%d.M%dP%dQ%d.%s", now.Unix(), now.Nanosecond()/1000,
+		os.Getpid(), sinkDeliveries.Add(1), hostname)
+}
@@ -0,0 +1,213 @@
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	dialTimeout = 10 * time.Second
+	sendTimeout = time.Minute
+)
+
+// smtpConn is an authenticated SMTP session which can be reused for several messages.
+type smtpConn struct {
+	conn     net.Conn
+	client   *smtp.Client
+	lastUsed time.Time
+	sent     int
+}
+
+type smtpDialer struct {
+	host      string
+	port      int
+	username  string
+	password  string
+	tlsMode   TLSMode
+	tlsConfig *tls.Config
+}
+
+func (d *smtpDialer) Dial(ctx context.Context) (*smtpConn, error) {
+	netDialer := net.Dialer{Timeout: dialTimeout}
+	conn, err := netDialer.DialContext(ctx, "tcp", net.JoinHostPort(d.host, fmt.Sprint(d.port)))
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(sendTimeout))
+	if d.tlsMode == ImplicitTLS {
+		conn = tls.Client(conn, d.tlsConfig)
+	}
+
+	client, err := smtp.NewClient(conn, d.host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if err = d.handshake(client); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+	return &smtpConn{conn: conn, client: client, lastUsed: time.Now()}, nil
+}
+
+func (d *smtpDialer) handshake(client *smtp.Client) error {
+	switch ok, _ := client.Extension("STARTTLS"); {
+	case d.tlsMode == StartTLS && !ok:
+		return errors.New("server doesn't support STARTTLS")
+	case d.tlsMode == StartTLS, d.tlsMode == OpportunisticStartTLS && ok:
+		if err := client.StartTLS(d.tlsConfig); err != nil {
+			return err
+		}
+	}
+
+	if d.username == "" {
+		return nil
+	}
+
+	ok, auths := client.Extension("AUTH")
+	if !ok {
+		// sending without the configured credentials would be rejected later with a confusing error
+		return errors.New("smtp: server doesn't support AUTH")
+	}
+
+	var auth smtp.Auth
+	switch {
+	case strings.Contains(auths, "PLAIN"):
+		auth = smtp.PlainAuth("", d.username, d.password, d.host)
+	case strings.Contains(auths, "LOGIN"):
+		auth = &loginAuth{username: d.username, password: d.password, host: d.host}
+	case strings.Contains(auths, "CRAM-MD5"):
+		auth = smtp.CRAMMD5Auth(d.username, d.password)
+	default:
+		return fmt.Errorf("no supported auth mechanism in %q", auths)
+	}
+
+	return client.Auth(auth)
+}
+
+// Send performs a single mail transaction on the session.
+func (c *smtpConn) Send(from string, to []string, msg io.WriterTo) error {
+	c.conn.SetDeadline(time.Now().Add(sendTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	c.lastUsed = time.Now()
+	if err := c.client.Mail(from); err != nil {
+		return err
+	}
+
+	for _, addr := range to {
+		if err := c.client.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.client.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err = msg.WriteTo(w); err != nil {
+		w.Close()
+		return err
+	}
+
+	if err = w.Close(); err != nil {
+		return err
+	}
+
+	c.sent++
+	return nil
+}
+
+// Reset aborts the current mail transaction so the session can be used again.
+func (c *smtpConn) Reset() error {
+	c.conn.SetDeadline(time.Now().Add(sendTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+	return c.client.Reset()
+}
+
+// Noop checks that the session is alive.
+func (c *smtpConn) Noop() error {
+	c.conn.SetDeadline(time.Now().Add(dialTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+	return c.client.Noop()
+}
+
+// Quit gracefully ends the session.
+func (c *smtpConn) Quit() error {
+	c.conn.SetDeadline(time.Now().Add(dialTimeout))
+	if err := c.client.Quit(); err != nil {
+		return c.client.Close()
+	}
+	return nil
+}
+
+func (c *smtpConn) Close() error {
+	return c.client.Close()
+}
+
+// isBrokenConn reports whether err means that the session can't be used anymore.
+func isBrokenConn(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		// 421 - service not available, closing transmission channel
+		return protoErr.Code == 421
+	}
+
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, net.ErrClosed) || isNetError(err)
+}
+
+func isNetError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// loginAuth implements the LOGIN authentication mechanism, which is not supported by net/smtp.
+type loginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && server.Name != "localhost" && server.Name != "127.0.0.1" {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	switch {
+	case strings.HasPrefix(strings.ToLower(string(fromServer)), "username"):
+		return []byte(a.username), nil
+	case strings.HasPrefix(strings.ToLower(string(fromServer)), "password"):
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("unexpected server challenge: %s", fromServer)
+}
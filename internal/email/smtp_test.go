@@ -0,0 +1,186 @@
+package email
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeSMTP is the in-process smtp server, it accepts any credentials and records the mail transactions.
+type fakeSMTP struct {
+	ln net.Listener
+
+	mu sync.Mutex
+	// reply to the MAIL command, transaction is accepted if empty
+	mailReply string
+	// session is closed without reply on the MAIL command after this number of messages
+	dropAfter int
+	// session is closed with 421 reply on the MAIL command after this number of messages
+	busyAfter int
+	// advertised auth mechanisms, AUTH isn't advertised if empty
+	authMechanisms string
+	// username of the last successful authentication
+	authUser     string
+	conns        int
+	quits        int
+	transactions []fakeTransaction
+}
+
+type fakeTransaction struct {
+	from string
+	to   []string
+	data string
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fakeSMTP{ln: ln}
+	go s.serve()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *fakeSMTP) relayConfig() RelayConfig {
+	return RelayConfig{
+		Name: s.ln.Addr().String(),
+		Host: "127.0.0.1",
+		Port: s.ln.Addr().(*net.TCPAddr).Port,
+		TLS:  TLSConfig{Mode: Plaintext},
+	}
+}
+
+func (s *fakeSMTP) set(fn func(s *fakeSMTP)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s)
+}
+
+func (s *fakeSMTP) stats() (conns, quits int, transactions []fakeTransaction) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns, s.quits, append([]fakeTransaction(nil), s.transactions...)
+}
+
+func (s *fakeSMTP) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns++
+		s.mu.Unlock()
+		go s.session(conn)
+	}
+}
+
+func (s *fakeSMTP) session(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+
+	reply("220 localhost ESMTP")
+	var sent int
+	var tx fakeTransaction
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(line)
+
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			s.mu.Lock()
+			mechanisms := s.authMechanisms
+			s.mu.Unlock()
+			reply("250-localhost")
+			if mechanisms != "" {
+				reply("250-AUTH " + mechanisms)
+			}
+			reply("250 8BITMIME")
+		case strings.HasPrefix(cmd, "AUTH PLAIN "):
+			// initial response is NUL separated authorization identity, username and password
+			credentials, err := base64.StdEncoding.DecodeString(line[len("AUTH PLAIN "):])
+			parts := strings.Split(string(credentials), "\x00")
+			if err != nil || len(parts) != 3 {
+				reply("501 malformed auth input")
+				continue
+			}
+			s.mu.Lock()
+			s.authUser = parts[1]
+			s.mu.Unlock()
+			reply("235 authentication succeeded")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			s.mu.Lock()
+			mailReply, dropAfter, busyAfter := s.mailReply, s.dropAfter, s.busyAfter
+			s.mu.Unlock()
+			switch {
+			case dropAfter > 0 && sent >= dropAfter:
+				return
+			case busyAfter > 0 && sent >= busyAfter:
+				reply("421 service not available, closing transmission channel")
+				return
+			case mailReply != "":
+				reply(mailReply)
+				continue
+			}
+			tx = fakeTransaction{from: addressOf(line)}
+			reply("250 ok")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			tx.to = append(tx.to, addressOf(line))
+			reply("250 ok")
+		case cmd == "DATA":
+			reply("354 end data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			tx.data = data.String()
+			s.mu.Lock()
+			s.transactions = append(s.transactions, tx)
+			s.mu.Unlock()
+			sent++
+			reply("250 queued")
+		case cmd == "RSET":
+			tx = fakeTransaction{}
+			reply("250 ok")
+		case cmd == "NOOP":
+			reply("250 ok")
+		case cmd == "QUIT":
+			s.mu.Lock()
+			s.quits++
+			s.mu.Unlock()
+			reply("221 bye")
+			return
+		default:
+			reply("502 command not implemented")
+		}
+	}
+}
+
+func addressOf(line string) string {
+	start, end := strings.Index(line, "<"), strings.LastIndex(line, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	return line[start+1 : end]
+}
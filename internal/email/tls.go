@@ -0,0 +1,85 @@
+package email
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+
+	"github.com/Falokut/email_service/pkg/certs"
+)
+
+type TLSMode = string
+
+const (
+	// tls handshake right after connecting, usually port 465
+	ImplicitTLS TLSMode = "IMPLICIT"
+	// STARTTLS is required, connection fails if server doesn't support it
+	StartTLS TLSMode = "STARTTLS"
+	// STARTTLS is used if server supports it
+	OpportunisticStartTLS TLSMode = "OPPORTUNISTIC_STARTTLS"
+	Plaintext             TLSMode = "PLAINTEXT"
+)
+
+type TLSConfig struct {
+	// if empty, mode is chosen by the port and enable_TLS
+	Mode TLSMode `yaml:"mode" env:"EMAIL_TLS_MODE"`
+	// pem encoded CA bundle, system pool is used if empty
+	CAFile string `yaml:"ca_file" env:"EMAIL_TLS_CA_FILE"`
+	// overrides server name used for certificate verification
+	ServerName string `yaml:"server_name" env:"EMAIL_TLS_SERVER_NAME"`
+	// 1.0, 1.1, 1.2 or 1.3
+	MinVersion string `yaml:"min_version" env:"EMAIL_TLS_MIN_VERSION"`
+	// client certificate, optional
+	CertFile           string `yaml:"cert_file" env:"EMAIL_TLS_CERT_FILE"`
+	KeyFile            string `yaml:"key_file" env:"EMAIL_TLS_KEY_FILE"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify" env:"EMAIL_TLS_INSECURE_SKIP_VERIFY"`
+}
+
+func tlsMode(cfg TLSConfig, port int, enableTLS bool) (TLSMode, error) {
+	switch mode := strings.ToUpper(cfg.Mode); mode {
+	case ImplicitTLS, StartTLS, OpportunisticStartTLS, Plaintext:
+		return mode, nil
+	case "":
+	default:
+		return "", fmt.Errorf("unsupported smtp tls mode %q", cfg.Mode)
+	}
+
+	switch {
+	case port == 465:
+		return ImplicitTLS, nil
+	case enableTLS:
+		return StartTLS, nil
+	}
+	return OpportunisticStartTLS, nil
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func (c TLSConfig) clientConfig(host string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         host,
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.ServerName != "" {
+		cfg.ServerName = c.ServerName
+	}
+
+	if c.MinVersion != "" {
+		version, ok := tlsVersions[c.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported tls version %q", c.MinVersion)
+		}
+		cfg.MinVersion = version
+	}
+
+	if err := certs.Load(cfg, c.CAFile, c.CertFile, c.KeyFile); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
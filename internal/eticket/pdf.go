@@ -0,0 +1,85 @@
+package eticket
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/Falokut/email_service/internal/models"
+	"github.com/go-pdf/fpdf"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+type Ticket struct {
+	Id   string
+	Row  int32
+	Seat int32
+	// formatted with the currency
+	Price string
+	// png image of the ticket barcode
+	BarCode []byte
+}
+
+const (
+	fontFamily = "go"
+	// page size in mm, A6
+	pageWidth  = 105.0
+	pageMargin = 10.0
+	lineHeight = 6.0
+)
+
+// NewPDF renders printable tickets, one page per ticket.
+func NewPDF(orderId string, screening models.Screening, tickets []Ticket) ([]byte, error) {
+	pdf := fpdf.New("P", "mm", "A6", "")
+	pdf.SetTitle(fmt.Sprintf("Заказ %s", orderId), true)
+	pdf.SetMargins(pageMargin, pageMargin, pageMargin)
+	pdf.SetAutoPageBreak(false, pageMargin)
+	// go fonts cover cyrillic, so no external fonts required
+	pdf.AddUTF8FontFromBytes(fontFamily, "", goregular.TTF)
+	pdf.AddUTF8FontFromBytes(fontFamily, "B", gobold.TTF)
+
+	contentWidth := pageWidth - 2*pageMargin
+	for i, ticket := range tickets {
+		pdf.AddPage()
+
+		pdf.SetFont(fontFamily, "B", 14)
+		pdf.MultiCell(contentWidth, lineHeight+1, screening.MovieName, "", "L", false)
+		pdf.Ln(2)
+
+		pdf.SetFont(fontFamily, "", 10)
+		line := func(label, value string) {
+			pdf.SetFont(fontFamily, "B", 10)
+			pdf.CellFormat(22, lineHeight, label, "", 0, "L", false, 0, "")
+			pdf.SetFont(fontFamily, "", 10)
+			pdf.MultiCell(contentWidth-22, lineHeight, value, "", "L", false)
+		}
+		line("Кинотеатр", screening.Cinema.Name)
+		line("Адрес", screening.Cinema.Address)
+		line("Зал", screening.HallName)
+		line("Дата", screening.StartDate)
+		line("Время", screening.StartTime)
+		line("Ряд", fmt.Sprint(ticket.Row))
+		line("Место", fmt.Sprint(ticket.Seat))
+		line("Цена", ticket.Price)
+
+		if len(ticket.BarCode) > 0 {
+			name := fmt.Sprintf("barcode-%d", i)
+			opts := fpdf.ImageOptions{ImageType: "PNG"}
+			pdf.RegisterImageOptionsReader(name, opts, bytes.NewReader(ticket.BarCode))
+			pdf.ImageOptions(name, pageMargin, pdf.GetY()+4, contentWidth, 25, true, opts, 0, "")
+		}
+
+		pdf.SetFont(fontFamily, "", 8)
+		pdf.CellFormat(contentWidth, lineHeight, ticket.Id, "", 1, "C", false, 0, "")
+	}
+
+	if err := pdf.Error(); err != nil {
+		return nil, err
+	}
+
+	var buff bytes.Buffer
+	if err := pdf.Output(&buff); err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
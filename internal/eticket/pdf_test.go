@@ -0,0 +1,44 @@
+package eticket
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"regexp"
+	"testing"
+
+	"github.com/Falokut/email_service/internal/models"
+)
+
+// page objects of the document, the page tree is /Type /Pages
+var pageObject = regexp.MustCompile(`/Type /Page\b`)
+
+func TestNewPDF(t *testing.T) {
+	var barcode bytes.Buffer
+	if err := png.Encode(&barcode, image.NewGray(image.Rect(0, 0, 200, 50))); err != nil {
+		t.Fatal(err)
+	}
+
+	screening := models.Screening{
+		MovieName: "Фильм",
+		Cinema:    models.Cinema{Name: "Кинотеатр", Address: "ул. Ленина, 1"},
+		HallName:  "Зал 1",
+		StartDate: "01.05",
+		StartTime: "19:30",
+	}
+	tickets := []Ticket{
+		{Id: "ticket-1", Row: 1, Seat: 2, Price: "350.00 руб.", BarCode: barcode.Bytes()},
+		{Id: "ticket-2", Row: 1, Seat: 3, Price: "350.00 руб."},
+	}
+
+	pdf, err := NewPDF("order-1", screening, tickets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(pdf, []byte("%PDF-")) {
+		t.Fatalf("output isn't the pdf document: %.20q", pdf)
+	}
+	if pages := len(pageObject.FindAll(pdf, -1)); pages != len(tickets) {
+		t.Errorf("%d pages, want one per ticket", pages)
+	}
+}
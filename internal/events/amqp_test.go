@@ -0,0 +1,58 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestAMQPDelivery(t *testing.T) {
+	headers := amqpHeaders(Message{
+		Key:     []byte("order-1"),
+		Headers: map[string]string{eventTypeHeader: "order_created"},
+	})
+	if got := headers[messageKeyHeader]; got != "order-1" {
+		t.Errorf("got key header %v, want %q", got, "order-1")
+	}
+	// headers set by the other publishers aren't always strings
+	headers[attemptHeader] = int32(2)
+	headers[originalTopicHeader] = []byte("orders")
+
+	timestamp := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	message := fromAMQPDelivery("orders.retry.1", amqp.Delivery{
+		Headers:     headers,
+		Body:        []byte("{}"),
+		Timestamp:   timestamp,
+		DeliveryTag: 7,
+	})
+	if message.Topic != "orders.retry.1" || string(message.Key) != "order-1" || string(message.Value) != "{}" {
+		t.Errorf("got topic %q, key %q, value %q", message.Topic, message.Key, message.Value)
+	}
+	wantHeaders := map[string]string{
+		eventTypeHeader:     "order_created",
+		attemptHeader:       "2",
+		originalTopicHeader: "orders",
+	}
+	for k, want := range wantHeaders {
+		if got := message.Headers[k]; got != want {
+			t.Errorf("header %s = %q, want %q", k, got, want)
+		}
+	}
+	if message.Offset != 7 || !message.Time.Equal(timestamp) {
+		t.Errorf("got offset %d and time %s, want the delivery tag 7 and %s", message.Offset, message.Time, timestamp)
+	}
+	// messages are acknowledged one by one
+	if message.Stream != "" || message.system != systemRabbitMQ {
+		t.Errorf("got stream %q and system %q", message.Stream, message.system)
+	}
+
+	before := time.Now()
+	message = fromAMQPDelivery("orders", amqp.Delivery{Body: []byte("{}")})
+	if message.Key != nil {
+		t.Errorf("got key %q of the message without key", message.Key)
+	}
+	if message.Time.Before(before) {
+		t.Errorf("got time %s of the message without timestamp, want the delivery time", message.Time)
+	}
+}
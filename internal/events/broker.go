@@ -0,0 +1,115 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// supported message brokers
+const (
+	BrokerKafka  = "KAFKA"
+	BrokerNATS   = "NATS"
+	BrokerAMQP   = "AMQP"
+	BrokerMemory = "MEMORY"
+)
+
+// messaging systems of the consumed messages, used in the spans
+const (
+	systemKafka    = "kafka"
+	systemNATS     = "nats"
+	systemRabbitMQ = "rabbitmq"
+	systemMemory   = "memory"
+)
+
+// header with the message key for the brokers without keys
+const messageKeyHeader = "x-message-key"
+
+type BrokerConfig struct {
+	// KAFKA, NATS, AMQP or MEMORY
+	Type string
+	// settings of the kafka readers and writers of the broker
+	Kafka KafkaReaderConfig
+	NATS  NATSConfig
+	AMQP  AMQPConfig
+}
+
+// Message is the message consumed from the broker or published into it.
+type Message struct {
+	// topic, subject or queue of the message
+	Topic   string
+	Key     []byte
+	Value   []byte
+	Headers map[string]string
+	Time    time.Time
+	// messages of the same stream are acknowledged in the fetch order, for kafka the stream is the partition.
+	// Messages without stream are acknowledged one by one.
+	Stream string
+	// position of the message in the stream, used in logs and retry headers:
+	// kafka partition and offset, jetstream sequence or amqp delivery tag
+	Partition int
+	Offset    int64
+
+	system string
+	// message of the broker client, used to acknowledge the message
+	raw any
+}
+
+// Consumer fetches messages of the subscribed topics from the broker.
+type Consumer interface {
+	// Fetch blocks until the next message is received or ctx is done.
+	Fetch(ctx context.Context) (Message, error)
+	// Ack acknowledges the handled messages, so they aren't delivered again.
+	Ack(ctx context.Context, messages ...Message) error
+	// Nack returns the unhandled messages to the broker for the redelivery.
+	// Delayed retries of the failed messages are published by the retrier.
+	Nack(ctx context.Context, messages ...Message) error
+	// Ping checks that the broker is reachable.
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// Publisher publishes messages into the retry, dead letter and quarantine topics.
+type Publisher interface {
+	Publish(ctx context.Context, topic string, message Message) error
+	Close() error
+}
+
+// Broker makes consumers and publishers of the configured message broker.
+type Broker interface {
+	// Consumer subscribes to the topics, name identifies the consumer in metrics and in the broker.
+	Consumer(name string, cfg ConsumerConfig, topics []string) (Consumer, error)
+	Publisher(cfg ConsumerConfig) (Publisher, error)
+	Close() error
+}
+
+func NewBroker(cfg BrokerConfig, logger *logrus.Logger) (Broker, error) {
+	switch strings.ToUpper(cfg.Type) {
+	case "", BrokerKafka:
+		return &kafkaBroker{cfg: cfg.Kafka, logger: logger}, nil
+	case BrokerNATS:
+		return newNATSBroker(cfg.NATS, logger)
+	case BrokerAMQP:
+		return newAMQPBroker(cfg.AMQP)
+	case BrokerMemory:
+		return NewMemoryBroker(), nil
+	}
+	return nil, fmt.Errorf("unsupported message broker %q", cfg.Type)
+}
+
+func header(message Message, key string) (string, bool) {
+	value, ok := message.Headers[key]
+	return value, ok
+}
+
+// copyHeaders copies headers of the message, so headers of the published copy can be changed.
+func copyHeaders(headers map[string]string) map[string]string {
+	res := make(map[string]string, len(headers)+1)
+	for k, v := range headers {
+		res[k] = v
+	}
+	return res
+}
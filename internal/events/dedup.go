@@ -0,0 +1,55 @@
+package events
+
+import (
+	"context"
+	"time"
+
+	"github.com/Falokut/email_service/internal/idempotency"
+	"github.com/Falokut/email_service/pkg/logging"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// deliverOnce calls send unless the mail for the event with the key is already sent,
+// skipped is true if the event is a duplicate. Without store send is always called.
+func deliverOnce(ctx context.Context, store idempotency.Store, logger *logrus.Logger,
+	key string, send func(ctx context.Context) error) (skipped bool, err error) {
+	if store == nil {
+		return false, send(ctx)
+	}
+
+	rec, found, err := store.Get(ctx, key)
+	switch {
+	case err != nil:
+		// duplicate is better than the lost mail, so store failure doesn't stop the delivery
+		logging.FromContext(ctx, logger).Warnf("can't get deduplication record: %v", err)
+	case found && rec.Step.Done():
+		logging.FromContext(ctx, logger).Infof("duplicate event skipped, mail was sent at %s", rec.UpdatedAt.Format(time.RFC3339))
+		trace.SpanFromContext(ctx).AddEvent("duplicate skipped")
+		return true, nil
+	case found:
+		logging.FromContext(ctx, logger).Warn("previous handling of the event wasn't completed, sending mail again")
+	}
+
+	saveStep(ctx, store, logger, key, idempotency.Started)
+	if err = send(ctx); err != nil {
+		return false, err
+	}
+	saveStep(ctx, store, logger, key, idempotency.Sent)
+	onAck(ctx, func() { markCommitted(ctx, store, logger, key) })
+	return false, nil
+}
+
+// markCommitted records that the event with the key is committed.
+func markCommitted(ctx context.Context, store idempotency.Store, logger *logrus.Logger, key string) {
+	if store == nil {
+		return
+	}
+	saveStep(ctx, store, logger, key, idempotency.Committed)
+}
+
+func saveStep(ctx context.Context, store idempotency.Store, logger *logrus.Logger, key string, step idempotency.Step) {
+	if err := store.Put(ctx, key, step); err != nil {
+		logging.FromContext(ctx, logger).Warnf("can't save deduplication step %s: %v", step, err)
+	}
+}
@@ -0,0 +1,59 @@
+package events
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Falokut/email_service/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+// handleError converts the error of the consumer into the service error.
+// Nil error isn't changed, even if ctx is done, so the handled message is still committed.
+func handleError(ctx context.Context, err *error) {
+	if err == nil || *err == nil {
+		return
+	}
+
+	if ctx.Err() != nil {
+		var code models.ErrorCode
+		switch {
+		case errors.Is(ctx.Err(), context.Canceled):
+			code = models.Canceled
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			code = models.DeadlineExceeded
+		}
+		*err = models.Error(code, ctx.Err().Error())
+		return
+	}
+
+	var serviceErr = &models.ServiceError{}
+	if !errors.As(*err, &serviceErr) {
+		*err = models.Error(models.Internal, "error while sending event notification")
+	}
+}
+
+// logError logs the error occurred in the consumer, which name is used in the message.
+func logError(logger logrus.FieldLogger, consumer string, err error, functionName string) {
+	if err == nil {
+		return
+	}
+
+	var eventsErr = &models.ServiceError{}
+	if errors.As(err, &eventsErr) {
+		logger.WithFields(
+			logrus.Fields{
+				"error.function.name": functionName,
+				"error.msg":           eventsErr.Msg,
+				"error.code":          eventsErr.Code,
+			},
+		).Error(consumer + " error occurred")
+	} else {
+		logger.WithFields(
+			logrus.Fields{
+				"error.function.name": functionName,
+				"error.msg":           err.Error(),
+			},
+		).Error(consumer + " error occurred")
+	}
+}
@@ -0,0 +1,45 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Falokut/email_service/internal/models"
+)
+
+func TestHandleError(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		err      error
+		wantNil  bool
+		wantCode models.ErrorCode
+	}{
+		{name: "handled", ctx: context.Background(), wantNil: true},
+		// message is handled before the shutdown, so it's committed
+		{name: "handled on shutdown", ctx: canceled, wantNil: true},
+		{name: "failed on shutdown", ctx: canceled, err: errors.New("smtp is down"), wantCode: models.Canceled},
+		{name: "service error", ctx: context.Background(),
+			err: models.Error(models.Rejected, "mailbox unavailable"), wantCode: models.Rejected},
+		{name: "unknown error", ctx: context.Background(), err: errors.New("broken pipe"), wantCode: models.Internal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.err
+			handleError(tt.ctx, &err)
+			if tt.wantNil {
+				if err != nil {
+					t.Fatalf("error %v, want nil", err)
+				}
+				return
+			}
+			if got := models.Code(err); got != tt.wantCode {
+				t.Errorf("error code %v, want %v", got, tt.wantCode)
+			}
+		})
+	}
+}
@@ -1,11 +1,95 @@
 package events
 
 import (
+	"context"
+	"errors"
+	"sync"
 	"time"
+
+	"github.com/segmentio/kafka-go"
 )
 
+// ConsumerConfig is the config of the events consumer, it's the same for all the brokers.
+type ConsumerConfig struct {
+	// kafka consumer group, prefix of the NATS durable consumer
+	Group string
+	Retry RetryConfig
+	Pool  PoolConfig
+
+	// consumer without the committed position reads the topics from the beginning
+	fromFirst bool
+}
+
+// KafkaReaderConfig is the kafka connection and reader settings of the events consumer.
 type KafkaReaderConfig struct {
 	Brokers          []string
-	GroupID          string
+	ClientID         string
 	ReadBatchTimeout time.Duration
+	// FIRST or LAST, used when the group has no committed offset
+	StartOffset string
+	MinBytes    int
+	MaxBytes    int
+	// how long the broker waits for the heartbeat before removing the reader from the group
+	SessionTimeout    time.Duration
+	HeartbeatInterval time.Duration
+	// RANGE or ROUND_ROBIN
+	RebalanceStrategy string
+	// offsets are committed periodically, zero commits synchronously
+	CommitInterval time.Duration
+	SASL           SASLConfig
+	TLS            TLSConfig
+}
+
+func pingBrokers(ctx context.Context, dialer *kafka.Dialer, brokers []string) error {
+	if len(brokers) == 0 {
+		return errors.New("no kafka brokers configured")
+	}
+
+	var errs []error
+	for _, broker := range brokers {
+		conn, err := dialer.DialContext(ctx, "tcp", broker)
+		if err == nil {
+			return conn.Close()
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// newRetryConsumer subscribes to the retry topics with the separate consumer, so retries waiting
+// for the backoff don't hold the workers of the fresh messages. Returns nil if there are no retry topics.
+func newRetryConsumer(broker Broker, name string, cfg ConsumerConfig, retryTopics []string) (Consumer, error) {
+	if len(retryTopics) == 0 {
+		return nil, nil
+	}
+	// retry topics are read from the beginning, so retries published before the first start aren't lost
+	cfg.fromFirst = true
+	return broker.Consumer(name+"_retry", cfg, retryTopics)
+}
+
+// runPools runs the pools until ctx is done, nil pools are skipped.
+func runPools(ctx context.Context, pools ...*workerPool) {
+	var wg sync.WaitGroup
+	for _, pool := range pools {
+		if pool == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(pool *workerPool) {
+			defer wg.Done()
+			pool.Run(ctx)
+		}(pool)
+	}
+	wg.Wait()
+}
+
+// closeConsumers closes the consumers, nil consumers are skipped.
+func closeConsumers(consumers ...Consumer) error {
+	var errs []error
+	for _, c := range consumers {
+		if c != nil {
+			errs = append(errs, c.Close())
+		}
+	}
+	return errors.Join(errs...)
 }
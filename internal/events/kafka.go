@@ -0,0 +1,289 @@
+package events
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Falokut/email_service/pkg/certs"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+	"github.com/sirupsen/logrus"
+)
+
+type SASLConfig struct {
+	// PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512, empty disables SASL
+	Mechanism string
+	Username  string
+	Password  string
+}
+
+type TLSConfig struct {
+	Enabled bool
+	// pem encoded CA bundle, system pool is used if empty
+	CAFile string
+	// client certificate, optional
+	CertFile           string
+	KeyFile            string
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+const (
+	saslPlain       = "PLAIN"
+	saslScramSHA256 = "SCRAM-SHA-256"
+	saslScramSHA512 = "SCRAM-SHA-512"
+
+	startOffsetFirst = "FIRST"
+	startOffsetLast  = "LAST"
+
+	rebalanceRange      = "RANGE"
+	rebalanceRoundRobin = "ROUND_ROBIN"
+
+	kafkaDialTimeout = 10 * time.Second
+	// default of the kafka-go reader, it's applied before the validation,
+	// so min_bytes can be set without max_bytes
+	defaultKafkaMaxBytes = 1e6
+)
+
+func (c SASLConfig) mechanism() (sasl.Mechanism, error) {
+	switch strings.ToUpper(c.Mechanism) {
+	case "":
+		return nil, nil
+	case saslPlain:
+		return plain.Mechanism{Username: c.Username, Password: c.Password}, nil
+	case saslScramSHA256:
+		return scram.Mechanism(scram.SHA256, c.Username, c.Password)
+	case saslScramSHA512:
+		return scram.Mechanism(scram.SHA512, c.Username, c.Password)
+	}
+	return nil, fmt.Errorf("unsupported kafka sasl mechanism %q", c.Mechanism)
+}
+
+func (c TLSConfig) clientConfig() (*tls.Config, error) {
+	if !c.Enabled {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{
+		ServerName:         c.ServerName,
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if err := certs.Load(cfg, c.CAFile, c.CertFile, c.KeyFile); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// kafkaClient holds the connection settings shared by the readers, the retry writer and the ping.
+type kafkaClient struct {
+	dialer    *kafka.Dialer
+	transport *kafka.Transport
+}
+
+func newKafkaClient(cfg KafkaReaderConfig) (*kafkaClient, error) {
+	mechanism, err := cfg.SASL.mechanism()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := cfg.TLS.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return &kafkaClient{
+		dialer: &kafka.Dialer{
+			ClientID:      cfg.ClientID,
+			Timeout:       kafkaDialTimeout,
+			DualStack:     true,
+			TLS:           tlsConfig,
+			SASLMechanism: mechanism,
+		},
+		transport: &kafka.Transport{
+			ClientID:    cfg.ClientID,
+			DialTimeout: kafkaDialTimeout,
+			TLS:         tlsConfig,
+			SASL:        mechanism,
+		},
+	}, nil
+}
+
+// readerConfig makes the config of the reader of the topics.
+func (c *kafkaClient) readerConfig(cfg KafkaReaderConfig, consumerCfg ConsumerConfig,
+	topics []string, logger *logrus.Logger) (kafka.ReaderConfig, error) {
+	readerCfg := kafka.ReaderConfig{
+		Brokers:           cfg.Brokers,
+		GroupTopics:       topics,
+		GroupID:           consumerCfg.Group,
+		Dialer:            c.dialer,
+		Logger:            logger,
+		ReadBatchTimeout:  cfg.ReadBatchTimeout,
+		MinBytes:          cfg.MinBytes,
+		MaxBytes:          cfg.MaxBytes,
+		SessionTimeout:    cfg.SessionTimeout,
+		HeartbeatInterval: cfg.HeartbeatInterval,
+		CommitInterval:    cfg.CommitInterval,
+	}
+
+	if readerCfg.MaxBytes == 0 {
+		readerCfg.MaxBytes = defaultKafkaMaxBytes
+	}
+
+	switch strings.ToUpper(cfg.StartOffset) {
+	case "", startOffsetFirst:
+		readerCfg.StartOffset = kafka.FirstOffset
+	case startOffsetLast:
+		readerCfg.StartOffset = kafka.LastOffset
+	default:
+		return kafka.ReaderConfig{}, fmt.Errorf("unsupported kafka start offset %q", cfg.StartOffset)
+	}
+	if consumerCfg.fromFirst {
+		readerCfg.StartOffset = kafka.FirstOffset
+	}
+
+	switch strings.ToUpper(cfg.RebalanceStrategy) {
+	case "", rebalanceRange:
+		readerCfg.GroupBalancers = []kafka.GroupBalancer{kafka.RangeGroupBalancer{}}
+	case rebalanceRoundRobin:
+		readerCfg.GroupBalancers = []kafka.GroupBalancer{kafka.RoundRobinGroupBalancer{}}
+	default:
+		return kafka.ReaderConfig{}, fmt.Errorf("unsupported kafka rebalance strategy %q", cfg.RebalanceStrategy)
+	}
+
+	if err := readerCfg.Validate(); err != nil {
+		return kafka.ReaderConfig{}, err
+	}
+	return readerCfg, nil
+}
+
+type kafkaBroker struct {
+	cfg    KafkaReaderConfig
+	logger *logrus.Logger
+}
+
+func (b *kafkaBroker) Consumer(name string, cfg ConsumerConfig, topics []string) (Consumer, error) {
+	client, err := newKafkaClient(b.cfg)
+	if err != nil {
+		return nil, err
+	}
+	readerCfg, err := client.readerConfig(b.cfg, cfg, topics, b.logger)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := kafka.NewReader(readerCfg)
+	readersStats.Add(name, reader)
+	return &kafkaConsumer{
+		reader:  reader,
+		dialer:  client.dialer,
+		brokers: b.cfg.Brokers,
+	}, nil
+}
+
+func (b *kafkaBroker) Publisher(cfg ConsumerConfig) (Publisher, error) {
+	client, err := newKafkaClient(b.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &kafkaPublisher{
+		writer: &kafka.Writer{
+			Addr:                   kafka.TCP(b.cfg.Brokers...),
+			Balancer:               &kafka.Hash{},
+			AllowAutoTopicCreation: true,
+			Transport:              client.transport,
+			Logger:                 b.logger,
+		},
+	}, nil
+}
+
+// Close does nothing, connections are held by the consumers and the publishers.
+func (b *kafkaBroker) Close() error {
+	return nil
+}
+
+type kafkaConsumer struct {
+	reader  *kafka.Reader
+	dialer  *kafka.Dialer
+	brokers []string
+}
+
+func (c *kafkaConsumer) Fetch(ctx context.Context) (Message, error) {
+	m, err := c.reader.FetchMessage(ctx)
+	if err != nil {
+		return Message{}, err
+	}
+	return fromKafkaMessage(m), nil
+}
+
+// Ack commits offsets of the messages.
+func (c *kafkaConsumer) Ack(ctx context.Context, messages ...Message) error {
+	msgs := make([]kafka.Message, 0, len(messages))
+	for _, m := range messages {
+		msgs = append(msgs, m.raw.(kafka.Message))
+	}
+	return c.reader.CommitMessages(ctx, msgs...)
+}
+
+// Nack does nothing, messages after the uncommitted offset are fetched again
+// after restart or rebalance.
+func (c *kafkaConsumer) Nack(ctx context.Context, messages ...Message) error {
+	return nil
+}
+
+// Ping checks that at least one of the kafka brokers is reachable.
+func (c *kafkaConsumer) Ping(ctx context.Context) error {
+	return pingBrokers(ctx, c.dialer, c.brokers)
+}
+
+func (c *kafkaConsumer) Close() error {
+	return c.reader.Close()
+}
+
+func fromKafkaMessage(m kafka.Message) Message {
+	headers := make(map[string]string, len(m.Headers))
+	for _, h := range m.Headers {
+		headers[h.Key] = string(h.Value)
+	}
+
+	return Message{
+		Topic:     m.Topic,
+		Key:       m.Key,
+		Value:     m.Value,
+		Headers:   headers,
+		Time:      m.Time,
+		Stream:    m.Topic + "/" + strconv.Itoa(m.Partition),
+		Partition: m.Partition,
+		Offset:    m.Offset,
+		system:    systemKafka,
+		raw:       m,
+	}
+}
+
+type kafkaPublisher struct {
+	writer *kafka.Writer
+}
+
+func (p *kafkaPublisher) Publish(ctx context.Context, topic string, message Message) error {
+	headers := make([]kafka.Header, 0, len(message.Headers))
+	for k, v := range message.Headers {
+		headers = append(headers, kafka.Header{Key: k, Value: []byte(v)})
+	}
+
+	return p.writer.WriteMessages(ctx, kafka.Message{
+		Topic:   topic,
+		Key:     message.Key,
+		Value:   message.Value,
+		Headers: headers,
+	})
+}
+
+func (p *kafkaPublisher) Close() error {
+	return p.writer.Close()
+}
@@ -0,0 +1,24 @@
+package events
+
+import (
+	"context"
+
+	"github.com/Falokut/email_service/pkg/logging"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// withMessageLogger puts the entry with the message coordinates into the context,
+// so every log line produced while handling the message can be correlated.
+func withMessageLogger(ctx context.Context, logger *logrus.Logger, message Message, eventType string) context.Context {
+	fields := logrus.Fields{
+		"topic":      message.Topic,
+		"partition":  message.Partition,
+		"offset":     message.Offset,
+		"event_type": eventType,
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		fields["trace_id"] = sc.TraceID().String()
+	}
+	return logging.ContextWithFields(ctx, logger, fields)
+}
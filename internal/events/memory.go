@@ -0,0 +1,142 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryBroker keeps the messages in memory, it's used in tests and to run the service without a broker.
+// Consumers of the same topic compete for its messages.
+type MemoryBroker struct {
+	mu      sync.Mutex
+	queued  []Message
+	acked   []Message
+	offsets map[string]int64
+	// closed and replaced when messages are queued, so the waiting consumers are woken up
+	notify chan struct{}
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		offsets: make(map[string]int64),
+		notify:  make(chan struct{}),
+	}
+}
+
+func (b *MemoryBroker) Consumer(name string, cfg ConsumerConfig, topics []string) (Consumer, error) {
+	subscribed := make(map[string]bool, len(topics))
+	for _, topic := range topics {
+		subscribed[topic] = true
+	}
+	return &memoryConsumer{broker: b, topics: subscribed}, nil
+}
+
+func (b *MemoryBroker) Publisher(cfg ConsumerConfig) (Publisher, error) {
+	return b, nil
+}
+
+// Publish queues the message into the topic.
+func (b *MemoryBroker) Publish(ctx context.Context, topic string, message Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	message.Topic = topic
+	message.Headers = copyHeaders(message.Headers)
+	message.Time = time.Now()
+	message.Offset = b.offsets[topic]
+	message.system = systemMemory
+	b.offsets[topic]++
+	b.queue(message)
+	return nil
+}
+
+// Acked returns the acknowledged messages in the order of acknowledgement.
+func (b *MemoryBroker) Acked() []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Message(nil), b.acked...)
+}
+
+// Queued returns the messages of the topic, which aren't fetched yet.
+func (b *MemoryBroker) Queued(topic string) []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var res []Message
+	for _, m := range b.queued {
+		if m.Topic == topic {
+			res = append(res, m)
+		}
+	}
+	return res
+}
+
+// Close does nothing, messages are kept until the broker is garbage collected.
+func (b *MemoryBroker) Close() error {
+	return nil
+}
+
+// queue must be called with the lock held.
+func (b *MemoryBroker) queue(messages ...Message) {
+	b.queued = append(b.queued, messages...)
+	close(b.notify)
+	b.notify = make(chan struct{})
+}
+
+// pop returns the first queued message of the topics or the channel, which is closed when messages are queued.
+func (b *MemoryBroker) pop(topics map[string]bool) (Message, bool, <-chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i, m := range b.queued {
+		if topics[m.Topic] {
+			b.queued = append(b.queued[:i], b.queued[i+1:]...)
+			return m, true, nil
+		}
+	}
+	return Message{}, false, b.notify
+}
+
+type memoryConsumer struct {
+	broker *MemoryBroker
+	topics map[string]bool
+}
+
+func (c *memoryConsumer) Fetch(ctx context.Context) (Message, error) {
+	for {
+		m, ok, notify := c.broker.pop(c.topics)
+		if ok {
+			return m, nil
+		}
+
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return Message{}, ctx.Err()
+		}
+	}
+}
+
+func (c *memoryConsumer) Ack(ctx context.Context, messages ...Message) error {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	c.broker.acked = append(c.broker.acked, messages...)
+	return nil
+}
+
+// Nack queues the messages again.
+func (c *memoryConsumer) Nack(ctx context.Context, messages ...Message) error {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	c.broker.queue(messages...)
+	return nil
+}
+
+func (c *memoryConsumer) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (c *memoryConsumer) Close() error {
+	return nil
+}
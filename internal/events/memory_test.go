@@ -0,0 +1,151 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Falokut/email_service/internal/health"
+	"github.com/sirupsen/logrus"
+)
+
+func fetchWithTimeout(t *testing.T, consumer Consumer) Message {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	message, err := consumer.Fetch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return message
+}
+
+func TestMemoryBrokerAckNack(t *testing.T) {
+	var broker Broker = NewMemoryBroker()
+	consumer, err := broker.Consumer("orders_events", ConsumerConfig{}, []string{"orders"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher, err := broker.Publisher(ConsumerConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for _, topic := range []string{"tokens", "orders"} {
+		err = publisher.Publish(ctx, topic, Message{Key: []byte(topic), Value: []byte("{}")})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	message := fetchWithTimeout(t, consumer)
+	if message.Topic != "orders" || string(message.Key) != "orders" {
+		t.Fatalf("fetched message of %s with key %q, want the message of the subscribed topic", message.Topic, message.Key)
+	}
+
+	if err = consumer.Nack(ctx, message); err != nil {
+		t.Fatal(err)
+	}
+	redelivered := fetchWithTimeout(t, consumer)
+	if redelivered.Topic != message.Topic || redelivered.Offset != message.Offset {
+		t.Fatalf("redelivered %s/%d, want %s/%d", redelivered.Topic, redelivered.Offset, message.Topic, message.Offset)
+	}
+
+	if err = consumer.Ack(ctx, redelivered); err != nil {
+		t.Fatal(err)
+	}
+	acked := broker.(*MemoryBroker).Acked()
+	if len(acked) != 1 || acked[0].Topic != "orders" {
+		t.Fatalf("acked %v, want the orders message", acked)
+	}
+	if queued := broker.(*MemoryBroker).Queued("tokens"); len(queued) != 1 {
+		t.Errorf("%d messages queued into tokens, want 1", len(queued))
+	}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err = consumer.Fetch(fetchCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("fetch error %v, want deadline exceeded", err)
+	}
+}
+
+// TestMemoryBrokerRetry runs the pools of the topic and the retry topics, message is failed on each attempt
+// and is moved into the dead letter topic after the last one.
+func TestMemoryBrokerRetry(t *testing.T) {
+	const backoff = 50 * time.Millisecond
+	var broker Broker = NewMemoryBroker()
+	publisher, err := broker.Publisher(ConsumerConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	retrier := newRetrier(publisher, RetryConfig{MaxAttempts: 3, InitialBackoff: backoff, Multiplier: 1}, logrus.New())
+
+	topics := []string{"orders"}
+	consumer, err := broker.Consumer("orders_events", ConsumerConfig{}, topics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	retryConsumer, err := newRetryConsumer(broker, "orders_events", ConsumerConfig{}, retrier.RetryTopics(topics...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var attempts []time.Time
+	handle := func(ctx context.Context, message Message, loop *health.Loop) error {
+		if err := retrier.Wait(ctx, message); err != nil {
+			return err
+		}
+		mu.Lock()
+		attempts = append(attempts, time.Now())
+		mu.Unlock()
+		return retrier.Retry(ctx, message, errors.New("smtp is down"))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pools := make(chan struct{})
+	go func() {
+		defer close(pools)
+		runPools(ctx,
+			newWorkerPool(PoolConfig{}, consumer, handle, logrus.New(), nil),
+			newWorkerPool(PoolConfig{}, retryConsumer, handle, logrus.New(), nil))
+	}()
+
+	if err = publisher.Publish(ctx, "orders", Message{Key: []byte("order-1"), Value: []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+
+	memory := broker.(*MemoryBroker)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(memory.Queued(deadLetterTopic("orders"))) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("message isn't moved into the dead letter topic")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-pools
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(attempts) != 3 {
+		t.Fatalf("message handled %d times, want 3", len(attempts))
+	}
+	for i := 1; i < len(attempts); i++ {
+		if delay := attempts[i].Sub(attempts[i-1]); delay < backoff {
+			t.Errorf("attempt %d handled after %s, want at least %s", i+1, delay, backoff)
+		}
+	}
+
+	dead := memory.Queued(deadLetterTopic("orders"))[0]
+	if dead.Headers[attemptHeader] != "3" || string(dead.Key) != "order-1" {
+		t.Errorf("dead letter attempt %s and key %q, want 3 and order-1", dead.Headers[attemptHeader], dead.Key)
+	}
+	if acked := memory.Acked(); len(acked) != 3 {
+		t.Errorf("%d messages acked, want the original message and 2 retries", len(acked))
+	}
+}
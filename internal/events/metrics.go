@@ -0,0 +1,124 @@
+package events
+
+import (
+	"sync"
+
+	"github.com/Falokut/email_service/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/segmentio/kafka-go"
+)
+
+// results of the consumed events handling
+const (
+	resultProcessed = "processed"
+	// event has invalid structure and was quarantined
+	resultSkipped = "skipped"
+	resultExpired = "expired"
+	// sending failed, event was moved into the retry or dead letter topic
+	resultFailed = "failed"
+	// mail for the event was already sent
+	resultDuplicate = "duplicate"
+)
+
+var (
+	consumedEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "events",
+		Name:      "consumed_total",
+		Help:      "Number of the consumed events by the original topic.",
+	}, []string{"topic"})
+
+	handledEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "events",
+		Name:      "handled_total",
+		Help:      "Number of the handled events by the original topic and result: processed, skipped, expired, failed or duplicate.",
+	}, []string{"topic", "result"})
+)
+
+func init() {
+	prometheus.MustRegister(readersStats)
+}
+
+type statsReader interface {
+	Stats() kafka.ReaderStats
+}
+
+// readersCollector exports kafka.Reader stats on scrape.
+// Reader resets counters on each Stats call, so collector accumulates them.
+type readersCollector struct {
+	mu      sync.Mutex
+	readers map[string]statsReader
+	totals  map[string]*readerTotals
+
+	lag        *prometheus.Desc
+	queue      *prometheus.Desc
+	messages   *prometheus.Desc
+	fetches    *prometheus.Desc
+	errors     *prometheus.Desc
+	timeouts   *prometheus.Desc
+	rebalances *prometheus.Desc
+}
+
+type readerTotals struct {
+	messages, fetches, errors, timeouts, rebalances int64
+}
+
+var readersStats = newReadersCollector()
+
+func newReadersCollector() *readersCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(metrics.Namespace, "kafka_reader", name), help, []string{"reader"}, nil)
+	}
+	return &readersCollector{
+		readers:    map[string]statsReader{},
+		totals:     map[string]*readerTotals{},
+		lag:        desc("lag", "Consumer lag of the reader."),
+		queue:      desc("queue_length", "Number of the fetched messages waiting in the reader queue."),
+		messages:   desc("messages_total", "Number of the messages read."),
+		fetches:    desc("fetches_total", "Number of the fetch requests."),
+		errors:     desc("errors_total", "Number of the fetch errors."),
+		timeouts:   desc("timeouts_total", "Number of the fetch timeouts."),
+		rebalances: desc("rebalances_total", "Number of the consumer group rebalances."),
+	}
+}
+
+// Add registers reader, which stats are exported with the name label.
+func (c *readersCollector) Add(name string, reader statsReader) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readers[name] = reader
+	if _, ok := c.totals[name]; !ok {
+		c.totals[name] = &readerTotals{}
+	}
+}
+
+func (c *readersCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{c.lag, c.queue, c.messages, c.fetches, c.errors, c.timeouts, c.rebalances} {
+		ch <- desc
+	}
+}
+
+func (c *readersCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for name, reader := range c.readers {
+		stats := reader.Stats()
+		totals := c.totals[name]
+		totals.messages += stats.Messages
+		totals.fetches += stats.Fetches
+		totals.errors += stats.Errors
+		totals.timeouts += stats.Timeouts
+		totals.rebalances += stats.Rebalances
+
+		ch <- prometheus.MustNewConstMetric(c.lag, prometheus.GaugeValue, float64(stats.Lag), name)
+		ch <- prometheus.MustNewConstMetric(c.queue, prometheus.GaugeValue, float64(stats.QueueLength), name)
+		ch <- prometheus.MustNewConstMetric(c.messages, prometheus.CounterValue, float64(totals.messages), name)
+		ch <- prometheus.MustNewConstMetric(c.fetches, prometheus.CounterValue, float64(totals.fetches), name)
+		ch <- prometheus.MustNewConstMetric(c.errors, prometheus.CounterValue, float64(totals.errors), name)
+		ch <- prometheus.MustNewConstMetric(c.timeouts, prometheus.CounterValue, float64(totals.timeouts), name)
+		ch <- prometheus.MustNewConstMetric(c.rebalances, prometheus.CounterValue, float64(totals.rebalances), name)
+	}
+}
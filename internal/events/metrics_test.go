@@ -0,0 +1,100 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
+)
+
+// fakeStatsReader returns the next stats on each call, like kafka.Reader resets counters after Stats.
+type fakeStatsReader struct {
+	stats []kafka.ReaderStats
+}
+
+func (r *fakeStatsReader) Stats() kafka.ReaderStats {
+	stats := r.stats[0]
+	r.stats = r.stats[1:]
+	return stats
+}
+
+func TestReadersCollector(t *testing.T) {
+	collector := newReadersCollector()
+	collector.Add("orders_events", &fakeStatsReader{stats: []kafka.ReaderStats{
+		{Lag: 10, QueueLength: 3, Messages: 5, Fetches: 2, Errors: 1, Timeouts: 1, Rebalances: 1},
+		{Lag: 4, QueueLength: 0, Messages: 7, Fetches: 3},
+	}})
+
+	metrics := []string{
+		"email_service_kafka_reader_lag",
+		"email_service_kafka_reader_queue_length",
+		"email_service_kafka_reader_messages_total",
+		"email_service_kafka_reader_fetches_total",
+		"email_service_kafka_reader_errors_total",
+	}
+	scrapes := []string{`
+# HELP email_service_kafka_reader_lag Consumer lag of the reader.
+# TYPE email_service_kafka_reader_lag gauge
+email_service_kafka_reader_lag{reader="orders_events"} 10
+# HELP email_service_kafka_reader_queue_length Number of the fetched messages waiting in the reader queue.
+# TYPE email_service_kafka_reader_queue_length gauge
+email_service_kafka_reader_queue_length{reader="orders_events"} 3
+# HELP email_service_kafka_reader_messages_total Number of the messages read.
+# TYPE email_service_kafka_reader_messages_total counter
+email_service_kafka_reader_messages_total{reader="orders_events"} 5
+# HELP email_service_kafka_reader_fetches_total Number of the fetch requests.
+# TYPE email_service_kafka_reader_fetches_total counter
+email_service_kafka_reader_fetches_total{reader="orders_events"} 2
+# HELP email_service_kafka_reader_errors_total Number of the fetch errors.
+# TYPE email_service_kafka_reader_errors_total counter
+email_service_kafka_reader_errors_total{reader="orders_events"} 1
+`, `
+# HELP email_service_kafka_reader_lag Consumer lag of the reader.
+# TYPE email_service_kafka_reader_lag gauge
+email_service_kafka_reader_lag{reader="orders_events"} 4
+# HELP email_service_kafka_reader_queue_length Number of the fetched messages waiting in the reader queue.
+# TYPE email_service_kafka_reader_queue_length gauge
+email_service_kafka_reader_queue_length{reader="orders_events"} 0
+# HELP email_service_kafka_reader_messages_total Number of the messages read.
+# TYPE email_service_kafka_reader_messages_total counter
+email_service_kafka_reader_messages_total{reader="orders_events"} 12
+# HELP email_service_kafka_reader_fetches_total Number of the fetch requests.
+# TYPE email_service_kafka_reader_fetches_total counter
+email_service_kafka_reader_fetches_total{reader="orders_events"} 5
+# HELP email_service_kafka_reader_errors_total Number of the fetch errors.
+# TYPE email_service_kafka_reader_errors_total counter
+email_service_kafka_reader_errors_total{reader="orders_events"} 1
+`}
+	// counters are accumulated between scrapes, gauges show the last value
+	for i, want := range scrapes {
+		if err := testutil.CollectAndCompare(collector, strings.NewReader(want), metrics...); err != nil {
+			t.Errorf("scrape %d: %v", i+1, err)
+		}
+	}
+}
+
+func TestQuarantineCountsSkippedEvent(t *testing.T) {
+	handledEvents.Reset()
+	r, _ := newTestRetrier(t, RetryConfig{})
+	message := Message{
+		Topic:   retryTopic("order_created", 1),
+		Value:   []byte("not json"),
+		Headers: map[string]string{originalTopicHeader: "order_created"},
+	}
+	if err := r.Quarantine(context.Background(), message, errors.New("invalid")); err != nil {
+		t.Fatal(err)
+	}
+
+	// retried event is counted by the original topic
+	want := `
+# HELP email_service_events_handled_total Number of the handled events by the original topic and result: processed, skipped, expired, failed or duplicate.
+# TYPE email_service_events_handled_total counter
+email_service_events_handled_total{result="skipped",topic="order_created"} 1
+`
+	if err := testutil.CollectAndCompare(handledEvents, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,318 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+)
+
+type NATSConfig struct {
+	URL string
+	// stream with the subjects of the topics, it's created or extended with the missing subjects on start
+	Stream    string
+	Username  string
+	Password  string
+	Token     string
+	CredsFile string
+	// unacknowledged messages are redelivered after it
+	AckWait time.Duration
+	TLS     TLSConfig
+}
+
+const (
+	defaultNATSStream  = "EMAIL_SERVICE"
+	defaultNATSAckWait = time.Minute
+	natsFetchWait      = 5 * time.Second
+	natsFetchBatch     = 10
+)
+
+type natsBroker struct {
+	conn   *nats.Conn
+	js     nats.JetStreamContext
+	cfg    NATSConfig
+	logger *logrus.Logger
+}
+
+func newNATSBroker(cfg NATSConfig, logger *logrus.Logger) (*natsBroker, error) {
+	if cfg.Stream == "" {
+		cfg.Stream = defaultNATSStream
+	}
+	if cfg.AckWait <= 0 {
+		cfg.AckWait = defaultNATSAckWait
+	}
+
+	opts := []nats.Option{nats.Name("email_service")}
+	switch {
+	case cfg.CredsFile != "":
+		opts = append(opts, nats.UserCredentials(cfg.CredsFile))
+	case cfg.Token != "":
+		opts = append(opts, nats.Token(cfg.Token))
+	case cfg.Username != "":
+		opts = append(opts, nats.UserInfo(cfg.Username, cfg.Password))
+	}
+	tlsConfig, err := cfg.TLS.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		opts = append(opts, nats.Secure(tlsConfig))
+	}
+
+	conn, err := nats.Connect(cfg.URL, opts...)
+	if err != nil {
+		return nil, err
+	}
+	js, err := conn.JetStream()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &natsBroker{conn: conn, js: js, cfg: cfg, logger: logger}, nil
+}
+
+// ensureStream creates the stream or adds the missing subjects of the topics into it.
+// Subject topic.> covers the retry, dead letter and quarantine subjects of the topic.
+func (b *natsBroker) ensureStream(topics []string) error {
+	var subjects []string
+	for _, topic := range topics {
+		subjects = append(subjects, topic, topic+".>")
+	}
+
+	info, err := b.js.StreamInfo(b.cfg.Stream)
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		_, err = b.js.AddStream(&nats.StreamConfig{
+			Name:     b.cfg.Stream,
+			Subjects: subjects,
+		})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	cfg := info.Config
+	var updated bool
+	for _, s := range subjects {
+		if !coveredSubject(cfg.Subjects, s) {
+			cfg.Subjects = append(cfg.Subjects, s)
+			updated = true
+		}
+	}
+	if !updated {
+		return nil
+	}
+	_, err = b.js.UpdateStream(&cfg)
+	return err
+}
+
+// coveredSubject reports whether the subject is one of the stream subjects or matches the stream wildcard,
+// e.g. the retry subjects are covered by the topic.> of the topic. Stream subjects can't overlap.
+func coveredSubject(streamSubjects []string, subject string) bool {
+	for _, s := range streamSubjects {
+		if s == subject || strings.HasSuffix(s, ".>") && strings.HasPrefix(subject, strings.TrimSuffix(s, ">")) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *natsBroker) Consumer(name string, cfg ConsumerConfig, topics []string) (Consumer, error) {
+	if err := b.ensureStream(topics); err != nil {
+		return nil, err
+	}
+
+	durable := durableName(cfg.Group, name)
+	if err := b.ensureConsumer(durable, cfg, topics); err != nil {
+		return nil, err
+	}
+
+	// bound subscription doesn't own the consumer, so the durable consumer and its position
+	// are kept when the subscription is closed
+	sub, err := b.js.PullSubscribe("", durable, nats.Bind(b.cfg.Stream, durable))
+	if err != nil {
+		return nil, err
+	}
+	return &natsConsumer{conn: b.conn, sub: sub, logger: b.logger}, nil
+}
+
+// durableName makes the name of the durable consumer of the group,
+// durable name can't contain dots and wildcards.
+func durableName(group, name string) string {
+	if group != "" {
+		name = group + "_" + name
+	}
+	return strings.NewReplacer(".", "_", "*", "_", ">", "_", " ", "_").Replace(name)
+}
+
+// ensureConsumer creates the durable consumer or updates the settings of the existing one.
+func (b *natsBroker) ensureConsumer(durable string, cfg ConsumerConfig, topics []string) error {
+	info, err := b.js.ConsumerInfo(b.cfg.Stream, durable)
+	if errors.Is(err, nats.ErrConsumerNotFound) {
+		_, err = b.js.AddConsumer(b.cfg.Stream, &nats.ConsumerConfig{
+			Durable:        durable,
+			FilterSubjects: topics,
+			AckPolicy:      nats.AckExplicitPolicy,
+			DeliverPolicy:  nats.DeliverAllPolicy,
+			AckWait:        b.cfg.AckWait,
+			MaxAckPending:  cfg.Pool.withDefaults().MaxInFlight,
+		})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	consumerCfg := info.Config
+	consumerCfg.FilterSubject = ""
+	consumerCfg.FilterSubjects = topics
+	consumerCfg.AckWait = b.cfg.AckWait
+	consumerCfg.MaxAckPending = cfg.Pool.withDefaults().MaxInFlight
+	_, err = b.js.UpdateConsumer(b.cfg.Stream, &consumerCfg)
+	return err
+}
+
+func (b *natsBroker) Publisher(cfg ConsumerConfig) (Publisher, error) {
+	return &natsPublisher{js: b.js}, nil
+}
+
+func (b *natsBroker) Close() error {
+	return b.conn.Drain()
+}
+
+type natsConsumer struct {
+	conn   *nats.Conn
+	sub    *nats.Subscription
+	logger *logrus.Logger
+	// messages of the last fetched batch
+	fetched []*nats.Msg
+}
+
+// Fetch is called by the one goroutine of the worker pool, so fetched batch isn't guarded.
+func (c *natsConsumer) Fetch(ctx context.Context) (Message, error) {
+	for {
+		if len(c.fetched) == 0 {
+			if err := c.fetchBatch(ctx); err != nil {
+				return Message{}, err
+			}
+			continue
+		}
+
+		msg := c.fetched[0]
+		c.fetched = c.fetched[1:]
+		message, err := fromNATSMessage(msg)
+		if err != nil {
+			return Message{}, err
+		}
+
+		// retries are delayed by the server, so they don't hold the workers while waiting
+		if retryAt, ok := retryTime(message); ok && time.Until(retryAt) > 0 {
+			if err := msg.NakWithDelay(time.Until(retryAt)); err != nil {
+				c.logger.Errorf("can't delay message %s/%d: %v", message.Topic, message.Offset, err)
+			}
+			continue
+		}
+		return message, nil
+	}
+}
+
+func (c *natsConsumer) fetchBatch(ctx context.Context) error {
+	fetchCtx, cancel := context.WithTimeout(ctx, natsFetchWait)
+	defer cancel()
+
+	msgs, err := c.sub.Fetch(natsFetchBatch, nats.Context(fetchCtx))
+	switch {
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, nats.ErrTimeout):
+		return nil
+	case err != nil:
+		return err
+	}
+	c.fetched = msgs
+	return nil
+}
+
+func (c *natsConsumer) Ack(ctx context.Context, messages ...Message) error {
+	var errs []error
+	for _, m := range messages {
+		errs = append(errs, m.raw.(*nats.Msg).Ack(nats.Context(ctx)))
+	}
+	return errors.Join(errs...)
+}
+
+func (c *natsConsumer) Nack(ctx context.Context, messages ...Message) error {
+	var errs []error
+	for _, m := range messages {
+		errs = append(errs, m.raw.(*nats.Msg).Nak(nats.Context(ctx)))
+	}
+	return errors.Join(errs...)
+}
+
+// Ping checks the connection with the round trip to the server.
+func (c *natsConsumer) Ping(ctx context.Context) error {
+	return c.conn.FlushWithContext(ctx)
+}
+
+// Close does nothing, the subscription isn't unsubscribed, so the durable consumer isn't deleted.
+// Connection is drained by the broker.
+func (c *natsConsumer) Close() error {
+	return nil
+}
+
+func fromNATSMessage(msg *nats.Msg) (Message, error) {
+	meta, err := msg.Metadata()
+	if err != nil {
+		return Message{}, err
+	}
+
+	headers := make(map[string]string, len(msg.Header))
+	for k := range msg.Header {
+		headers[k] = msg.Header.Get(k)
+	}
+	var key []byte
+	if k, ok := headers[messageKeyHeader]; ok {
+		key = []byte(k)
+	}
+
+	return Message{
+		Topic:   msg.Subject,
+		Key:     key,
+		Value:   msg.Data,
+		Headers: headers,
+		Time:    meta.Timestamp,
+		Offset:  int64(meta.Sequence.Stream),
+		system:  systemNATS,
+		raw:     msg,
+	}, nil
+}
+
+type natsPublisher struct {
+	js nats.JetStreamContext
+}
+
+func (p *natsPublisher) Publish(ctx context.Context, topic string, message Message) error {
+	_, err := p.js.PublishMsg(toNATSMessage(topic, message), nats.Context(ctx))
+	return err
+}
+
+// toNATSMessage makes the message of the subject, the key is passed in the header.
+func toNATSMessage(subject string, message Message) *nats.Msg {
+	msg := nats.NewMsg(subject)
+	msg.Data = message.Value
+	for k, v := range message.Headers {
+		msg.Header.Set(k, v)
+	}
+	if len(message.Key) > 0 {
+		msg.Header.Set(messageKeyHeader, string(message.Key))
+	}
+	return msg
+}
+
+// Close does nothing, connection is closed by the broker.
+func (p *natsPublisher) Close() error {
+	return nil
+}
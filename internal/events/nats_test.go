@@ -0,0 +1,93 @@
+package events
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestNATSMessage(t *testing.T) {
+	published := toNATSMessage("orders", Message{
+		Key:     []byte("order-1"),
+		Value:   []byte("{}"),
+		Headers: map[string]string{eventTypeHeader: "order_created"},
+	})
+	if got := published.Header.Get(messageKeyHeader); got != "order-1" {
+		t.Errorf("got key header %q, want %q", got, "order-1")
+	}
+
+	// delivered message is bound to the subscription and has the jetstream ack subject
+	timestamp := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	published.Sub = &nats.Subscription{}
+	published.Reply = "$JS.ACK.EMAIL_SERVICE.orders_events.1.42.7." + strconv.FormatInt(timestamp.UnixNano(), 10) + ".0"
+	message, err := fromNATSMessage(published)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Topic != "orders" || string(message.Key) != "order-1" || string(message.Value) != "{}" {
+		t.Errorf("got topic %q, key %q, value %q", message.Topic, message.Key, message.Value)
+	}
+	if message.Headers[eventTypeHeader] != "order_created" {
+		t.Errorf("got headers %v, want the published ones", message.Headers)
+	}
+	if message.Offset != 42 || !message.Time.Equal(timestamp) {
+		t.Errorf("got offset %d and time %s, want the stream sequence 42 and %s", message.Offset, message.Time, timestamp)
+	}
+	// messages are acknowledged one by one
+	if message.Stream != "" || message.system != systemNATS {
+		t.Errorf("got stream %q and system %q", message.Stream, message.system)
+	}
+
+	withoutKey := toNATSMessage("orders", Message{Value: []byte("{}")})
+	withoutKey.Sub, withoutKey.Reply = published.Sub, published.Reply
+	if message, err = fromNATSMessage(withoutKey); err != nil {
+		t.Fatal(err)
+	}
+	if message.Key != nil {
+		t.Errorf("got key %q of the message without key", message.Key)
+	}
+
+	if _, err = fromNATSMessage(nats.NewMsg("orders")); err == nil {
+		t.Error("message without the ack subject is converted")
+	}
+}
+
+func TestCoveredSubject(t *testing.T) {
+	streamSubjects := []string{"orders", "orders.>", "tokens"}
+	tests := []struct {
+		subject string
+		want    bool
+	}{
+		{subject: "orders", want: true},
+		{subject: retryTopic("orders", 1), want: true},
+		{subject: deadLetterTopic("orders"), want: true},
+		{subject: "tokens", want: true},
+		// tokens.> isn't in the stream
+		{subject: quarantineTopic("tokens"), want: false},
+		{subject: "orders_archive", want: false},
+		{subject: "payments", want: false},
+	}
+	for _, tt := range tests {
+		if got := coveredSubject(streamSubjects, tt.subject); got != tt.want {
+			t.Errorf("coveredSubject(%q): got %t, want %t", tt.subject, got, tt.want)
+		}
+	}
+}
+
+func TestDurableName(t *testing.T) {
+	tests := []struct {
+		group, name, want string
+	}{
+		{group: "email_service", name: "orders_events", want: "email_service_orders_events"},
+		{name: "orders_events", want: "orders_events"},
+		{group: "email.service", name: "orders_events_retry", want: "email_service_orders_events_retry"},
+		{group: "email service", name: "orders.*.>", want: "email_service_orders____"},
+	}
+	for _, tt := range tests {
+		if got := durableName(tt.group, tt.name); got != tt.want {
+			t.Errorf("durableName(%q, %q): got %q, want %q", tt.group, tt.name, got, tt.want)
+		}
+	}
+}
@@ -5,104 +5,87 @@ import (
 	"encoding/json"
 	"errors"
 
+	"github.com/Falokut/email_service/internal/health"
+	"github.com/Falokut/email_service/internal/idempotency"
 	"github.com/Falokut/email_service/internal/models"
 	"github.com/Falokut/email_service/internal/service"
-	"github.com/segmentio/kafka-go"
+	"github.com/Falokut/email_service/internal/tracing"
+	"github.com/Falokut/email_service/pkg/logging"
 	"github.com/sirupsen/logrus"
 )
 
 type ordersEventsConsumer struct {
-	reader  *kafka.Reader
-	logger  *logrus.Logger
-	service service.MailService
+	consumer Consumer
+	// consumer of the retry topics, nil if messages aren't retried
+	retryConsumer Consumer
+	retrier       *retrier
+	logger        *logrus.Logger
+	service       service.MailService
+	// handled events records, nil if deduplication is disabled
+	dedup     idempotency.Store
+	routes    *RoutingTable
+	pool      *workerPool
+	retryPool *workerPool
 }
 
-const (
-	orderCreatedTopic = "order_created"
-)
-
 func NewOrdersEventsConsumer(
-	cfg KafkaReaderConfig,
+	cfg ConsumerConfig,
+	broker Broker,
 	logger *logrus.Logger,
-	service service.MailService) *ordersEventsConsumer {
-	r := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:          cfg.Brokers,
-		GroupTopics:      []string{orderCreatedTopic},
-		GroupID:          cfg.GroupID,
-		Logger:           logger,
-		ReadBatchTimeout: cfg.ReadBatchTimeout,
-	})
-
-	return &ordersEventsConsumer{
-		reader:  r,
-		logger:  logger,
-		service: service,
+	service service.MailService,
+	probes *health.Server,
+	dedup idempotency.Store,
+	routes *RoutingTable) (*ordersEventsConsumer, error) {
+	publisher, err := broker.Publisher(cfg)
+	if err != nil {
+		return nil, err
 	}
-}
+	retrier := newRetrier(publisher, cfg.Retry, logger)
 
-func (c *ordersEventsConsumer) Run(ctx context.Context) {
-	for {
-		select {
-		default:
-			c.Consume(ctx)
-		case <-ctx.Done():
-			c.logger.Info("orders events consumer shutting down")
-			c.reader.Close()
-			c.logger.Info("orders events consumer shutted down")
-			return
-		}
+	topics := routes.Topics(OrderCreatedHandler)
+	consumer, err := broker.Consumer("orders_events", cfg, topics)
+	if err != nil {
+		retrier.Close()
+		return nil, err
 	}
-}
-
-func (e *ordersEventsConsumer) Shutdown() error {
-	return e.reader.Close()
-}
-
-func (e *ordersEventsConsumer) handleError(ctx context.Context, err *error) {
-	if ctx.Err() != nil {
-		var code models.ErrorCode
-		switch {
-		case errors.Is(ctx.Err(), context.Canceled):
-			code = models.Canceled
-		case errors.Is(ctx.Err(), context.DeadlineExceeded):
-			code = models.DeadlineExceeded
-		}
-		*err = models.Error(code, ctx.Err().Error())
-		return
+	retryConsumer, err := newRetryConsumer(broker, "orders_events", cfg, retrier.RetryTopics(topics...))
+	if err != nil {
+		consumer.Close()
+		retrier.Close()
+		return nil, err
 	}
 
-	if err == nil || *err == nil {
-		return
+	c := &ordersEventsConsumer{
+		consumer:      consumer,
+		retryConsumer: retryConsumer,
+		retrier:       retrier,
+		logger:        logger,
+		service:       service,
+		dedup:         dedup,
+		routes:        routes,
 	}
-
-	var serviceErr = &models.ServiceError{}
-	if !errors.As(*err, &serviceErr) {
-		*err = models.Error(models.Internal, "error while sending event notification")
+	c.pool = newWorkerPool(cfg.Pool, consumer, c.Consume, logger, probes.Loop("orders_events"))
+	if retryConsumer != nil {
+		c.retryPool = newWorkerPool(cfg.Pool, retryConsumer, c.Consume, logger, probes.Loop("orders_events_retry"))
 	}
+	return c, nil
 }
 
-func (e *ordersEventsConsumer) logError(err error, functionName string) {
-	if err == nil {
-		return
-	}
+func (c *ordersEventsConsumer) Run(ctx context.Context) {
+	runPools(ctx, c.pool, c.retryPool)
 
-	var eventsErr = &models.ServiceError{}
-	if errors.As(err, &eventsErr) {
-		e.logger.WithFields(
-			logrus.Fields{
-				"error.function.name": functionName,
-				"error.msg":           eventsErr.Msg,
-				"error.code":          eventsErr.Code,
-			},
-		).Error("tokens delivery error occurred")
-	} else {
-		e.logger.WithFields(
-			logrus.Fields{
-				"error.function.name": functionName,
-				"error.msg":           err.Error(),
-			},
-		).Error("tokens delivery error occurred")
-	}
+	c.logger.Info("orders events consumer shutting down")
+	c.Shutdown()
+	c.logger.Info("orders events consumer shutted down")
+}
+
+// Ping checks that the message broker is reachable.
+func (c *ordersEventsConsumer) Ping(ctx context.Context) error {
+	return c.consumer.Ping(ctx)
+}
+
+func (e *ordersEventsConsumer) Shutdown() error {
+	return errors.Join(closeConsumers(e.consumer, e.retryConsumer), e.retrier.Close())
 }
 
 type orderCreated struct {
@@ -110,28 +93,75 @@ type orderCreated struct {
 	Order models.Order `json:"order"`
 }
 
-func (c *ordersEventsConsumer) Consume(ctx context.Context) {
-	var err error
-	defer c.handleError(ctx, &err)
+func (e orderCreated) validate() error {
+	switch {
+	case e.Email == "":
+		return models.Error(models.InvalidArgument, "email is empty")
+	// order id is the deduplication key of the event
+	case e.Order.Id == "":
+		return models.Error(models.InvalidArgument, "order.id is empty")
+	case len(e.Order.Tickets) == 0:
+		return models.Error(models.InvalidArgument, "order.tickets is empty")
+	case e.Order.ScreeningId == 0:
+		return models.Error(models.InvalidArgument, "order.screening_id is zero")
+	}
+	return nil
+}
 
-	message, err := c.reader.FetchMessage(ctx)
-	if err != nil {
-		return
+// Consume handles the fetched message, the message is acknowledged by the worker pool.
+func (c *ordersEventsConsumer) Consume(ctx context.Context, message Message, loop *health.Loop) (err error) {
+	defer handleError(ctx, &err)
+
+	topic := originalTopic(message)
+	consumedEvents.WithLabelValues(topic).Inc()
+	if err = c.retrier.Wait(ctx, message); err != nil {
+		return err
+	}
+	defer loop.Busy()()
+
+	ctx, span := startProcessSpan(ctx, message)
+	defer span.End()
+	eventType := eventType(message)
+	ctx = withMessageLogger(ctx, c.logger, message, eventType)
+
+	route, ok := c.routes.Match(topic, eventType)
+	if !ok {
+		err = models.Errorf(models.InvalidArgument, "no route for the event type %s", eventType)
+		tracing.RecordError(span, err)
+		return c.retrier.Quarantine(ctx, message, err)
 	}
 
 	var orderCreated orderCreated
 
+	_, unmarshalSpan := tracer.Start(ctx, "unmarshal")
 	err = json.Unmarshal(message.Value, &orderCreated)
-	if err != nil {
-		// skip messages with invalid structure
-		err = c.reader.CommitMessages(ctx, message)
-		return
+	if err == nil {
+		err = orderCreated.validate()
 	}
-
-	err = c.service.SendOrderCreatedNotification(ctx, orderCreated.Email, orderCreated.Order)
+	tracing.EndSpan(unmarshalSpan, err)
 	if err != nil {
-		return
+		tracing.RecordError(span, err)
+		return c.retrier.Quarantine(ctx, message, err)
 	}
+	ctx = logging.ContextWithFields(ctx, c.logger, logrus.Fields{
+		"order_id":  orderCreated.Order.Id,
+		"recipient": logging.HashRecipient(orderCreated.Email),
+	})
 
-	err = c.reader.CommitMessages(ctx, message)
+	key := idempotency.Key(eventType, orderCreated.Order.Id)
+	duplicate, err := deliverOnce(ctx, c.dedup, c.logger, key, func(ctx context.Context) error {
+		return c.service.SendOrderCreatedNotification(ctx, orderCreated.Email, orderCreated.Order, route.MailTemplate())
+	})
+	switch {
+	case err != nil:
+		logError(logging.FromContext(ctx, c.logger), "orders events", err, "Consume")
+		tracing.RecordError(span, err)
+		handledEvents.WithLabelValues(topic, resultFailed).Inc()
+		return c.retrier.Retry(ctx, message, err)
+	case duplicate:
+		handledEvents.WithLabelValues(topic, resultDuplicate).Inc()
+	default:
+		handledEvents.WithLabelValues(topic, resultProcessed).Inc()
+	}
+	return nil
 }
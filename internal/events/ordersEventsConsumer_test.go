@@ -0,0 +1,40 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/Falokut/email_service/internal/models"
+)
+
+func TestOrderCreatedValidate(t *testing.T) {
+	valid := func() orderCreated {
+		return orderCreated{
+			Email: "user@example.com",
+			Order: models.Order{Id: "1", ScreeningId: 1, Tickets: []models.Ticket{{Id: "1"}}},
+		}
+	}
+	tests := []struct {
+		name    string
+		modify  func(e *orderCreated)
+		wantErr bool
+	}{
+		{name: "valid", modify: func(e *orderCreated) {}},
+		{name: "empty email", modify: func(e *orderCreated) { e.Email = "" }, wantErr: true},
+		{name: "empty order id", modify: func(e *orderCreated) { e.Order.Id = "" }, wantErr: true},
+		{name: "no tickets", modify: func(e *orderCreated) { e.Order.Tickets = nil }, wantErr: true},
+		{name: "zero screening id", modify: func(e *orderCreated) { e.Order.ScreeningId = 0 }, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := valid()
+			tt.modify(&e)
+			err := e.validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil && models.Code(err) != models.InvalidArgument {
+				t.Errorf("got code %v, want %v", models.Code(err), models.InvalidArgument)
+			}
+		})
+	}
+}
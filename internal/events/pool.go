@@ -0,0 +1,389 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Falokut/email_service/internal/health"
+	"github.com/sirupsen/logrus"
+)
+
+type PoolConfig struct {
+	// number of messages handled in parallel
+	Workers int
+	// max number of fetched, but not acknowledged messages, consumer stops fetching when it's reached
+	MaxInFlight int
+	// PARTITION keeps the order of messages within the kafka partition or the topic, KEY within the message key
+	OrderBy string
+}
+
+const (
+	orderByPartition = "PARTITION"
+	orderByKey       = "KEY"
+
+	defaultWorkers           = 1
+	defaultInFlightPerWorker = 10
+
+	// delay before fetching again after the fetch error, it's doubled on each error in a row
+	minFetchBackoff = 100 * time.Millisecond
+	maxFetchBackoff = 10 * time.Second
+	fetchFailure    = "fetch"
+)
+
+// delay before handling the failed message again, it's doubled on each failure in a row,
+// replaced in tests
+var (
+	minHandleBackoff = time.Second
+	maxHandleBackoff = time.Minute
+)
+
+func (c PoolConfig) withDefaults() PoolConfig {
+	if c.Workers <= 0 {
+		c.Workers = defaultWorkers
+	}
+	if c.MaxInFlight <= 0 {
+		c.MaxInFlight = c.Workers * defaultInFlightPerWorker
+	}
+	c.OrderBy = strings.ToUpper(c.OrderBy)
+	if c.OrderBy != orderByKey {
+		c.OrderBy = orderByPartition
+	}
+	return c
+}
+
+// handleFunc handles the message. Message is acknowledged if nil is returned,
+// otherwise it's handled again after the backoff.
+type handleFunc func(ctx context.Context, message Message, loop *health.Loop) error
+
+// workerPool handles messages of the consumer in parallel. Messages with the same partition or key
+// are handled by the same worker one by one, so their order is kept.
+// Message of the stream is acknowledged only when all the messages of the stream before it are handled.
+type workerPool struct {
+	cfg      PoolConfig
+	consumer Consumer
+	handle   handleFunc
+	logger   *logrus.Logger
+	loop     *health.Loop
+}
+
+func newWorkerPool(cfg PoolConfig, consumer Consumer, handle handleFunc,
+	logger *logrus.Logger, loop *health.Loop) *workerPool {
+	return &workerPool{
+		cfg:      cfg.withDefaults(),
+		consumer: consumer,
+		handle:   handle,
+		logger:   logger,
+		loop:     loop,
+	}
+}
+
+// Run fetches and handles messages until ctx is done.
+func (p *workerPool) Run(ctx context.Context) {
+	defer p.loop.Stop()
+
+	// each fetched message holds one slot until it's acknowledged or leaves the lane untracked
+	inFlight := make(chan struct{}, p.cfg.MaxInFlight)
+	acks := newAckTracker(p.consumer, p.logger, func() { <-inFlight })
+
+	lanes := make([]chan *pendingMessage, p.cfg.Workers)
+	var wg sync.WaitGroup
+	for i := range lanes {
+		lanes[i] = make(chan *pendingMessage, p.cfg.MaxInFlight)
+		wg.Add(1)
+		go func(lane <-chan *pendingMessage) {
+			defer wg.Done()
+			for m := range lane {
+				p.work(ctx, acks, m)
+			}
+		}(lanes[i])
+	}
+
+	defer func() {
+		for _, lane := range lanes {
+			close(lane)
+		}
+		wg.Wait()
+	}()
+
+	backoff := minFetchBackoff
+	for {
+		select {
+		case inFlight <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+
+		message, err := p.consumer.Fetch(ctx)
+		if err != nil {
+			<-inFlight
+			if ctx.Err() != nil {
+				return
+			}
+			p.logger.Errorf("can't fetch message: %v", err)
+			p.loop.Fail(fetchFailure, err)
+			if !sleep(ctx, backoff) {
+				return
+			}
+			backoff = min(backoff*2, maxFetchBackoff)
+			continue
+		}
+		if backoff != minFetchBackoff {
+			p.loop.Recover(fetchFailure)
+			backoff = minFetchBackoff
+		}
+		lanes[p.lane(message)] <- acks.Add(message)
+	}
+}
+
+// work handles the message until it succeeds. Stream can't be acknowledged past the failed message,
+// so the message isn't skipped, it's handled again after the backoff and the next messages of the lane wait.
+// Message failing longer than the stall timeout makes the loop not alive.
+func (p *workerPool) work(ctx context.Context, acks *ackTracker, m *pendingMessage) {
+	failure := fmt.Sprintf("message %s/%d/%d", m.message.Topic, m.message.Partition, m.message.Offset)
+	backoff := minHandleBackoff
+	for failed := false; ; failed = true {
+		switch {
+		case ctx.Err() != nil:
+			acks.Abandon(ctx, m)
+			return
+		case acks.Skip(m):
+			// stream is fetched again, message will be handled again from the new fetch
+			if failed {
+				p.loop.Recover(failure)
+			}
+			return
+		}
+
+		err := p.handle(withPendingMessage(ctx, m), m.message, p.loop)
+		if err == nil {
+			// handled message is acknowledged even on shutdown, so it isn't handled again after restart
+			acks.Done(context.WithoutCancel(ctx), m)
+			if failed {
+				p.loop.Recover(failure)
+			}
+			return
+		}
+		if ctx.Err() != nil {
+			acks.Abandon(ctx, m)
+			return
+		}
+
+		p.logger.Warnf("message %s/%d/%d isn't handled, it's handled again in %s: %v",
+			m.message.Topic, m.message.Partition, m.message.Offset, backoff, err)
+		p.loop.Fail(failure, err)
+		if !sleep(ctx, backoff) {
+			acks.Abandon(ctx, m)
+			return
+		}
+		backoff = min(backoff*2, maxHandleBackoff)
+	}
+}
+
+func (p *workerPool) lane(message Message) int {
+	h := fnv.New32a()
+	switch {
+	case p.cfg.OrderBy == orderByKey && len(message.Key) > 0:
+		h.Write(message.Key)
+	case message.Stream != "":
+		h.Write([]byte(message.Stream))
+	default:
+		h.Write([]byte(message.Topic))
+	}
+	return int(h.Sum32() % uint32(p.cfg.Workers))
+}
+
+type pendingMessage struct {
+	message Message
+	done    bool
+	// message is queued in the lane or is being handled
+	inLane bool
+	// message is in the pending messages of its stream, until it's acknowledged
+	// or the stream is fetched again after rebalance
+	tracked  bool
+	released bool
+	// called after the message is acknowledged
+	hooks []func()
+}
+
+type pendingMessageKey struct{}
+
+func withPendingMessage(ctx context.Context, m *pendingMessage) context.Context {
+	return context.WithValue(ctx, pendingMessageKey{}, m)
+}
+
+// onAck registers fn, which is called after the message handled with ctx is acknowledged.
+// fn isn't called if the message isn't acknowledged.
+func onAck(ctx context.Context, fn func()) {
+	if m, ok := ctx.Value(pendingMessageKey{}).(*pendingMessage); ok {
+		m.hooks = append(m.hooks, fn)
+	}
+}
+
+// streamMessages keeps the fetched messages of the stream in the offset order.
+type streamMessages struct {
+	mu      sync.Mutex
+	pending []*pendingMessage
+}
+
+// ackTracker acknowledges the contiguous prefix of the handled messages of each stream,
+// messages without stream are acknowledged as soon as they are handled.
+type ackTracker struct {
+	consumer Consumer
+	logger   *logrus.Logger
+	release  func()
+
+	mu      sync.Mutex
+	streams map[string]*streamMessages
+	// guards the messages without stream
+	unordered sync.Mutex
+}
+
+func newAckTracker(consumer Consumer, logger *logrus.Logger, release func()) *ackTracker {
+	return &ackTracker{
+		consumer: consumer,
+		logger:   logger,
+		release:  release,
+		streams:  make(map[string]*streamMessages),
+	}
+}
+
+// lock locks the stream of the message, returned stream is nil for the message without stream.
+func (t *ackTracker) lock(message Message) (s *streamMessages, unlock func()) {
+	if message.Stream == "" {
+		t.unordered.Lock()
+		return nil, t.unordered.Unlock
+	}
+
+	t.mu.Lock()
+	s, ok := t.streams[message.Stream]
+	if !ok {
+		s = &streamMessages{}
+		t.streams[message.Stream] = s
+	}
+	t.mu.Unlock()
+
+	s.mu.Lock()
+	return s, s.mu.Unlock
+}
+
+// settle frees the slot of the message once it's out of the lane and isn't tracked,
+// must be called with the stream locked.
+func (t *ackTracker) settle(m *pendingMessage) {
+	if !m.inLane && !m.tracked && !m.released {
+		m.released = true
+		t.release()
+	}
+}
+
+// Add starts tracking of the fetched message.
+func (t *ackTracker) Add(message Message) *pendingMessage {
+	m := &pendingMessage{message: message, inLane: true, tracked: true}
+	s, unlock := t.lock(message)
+	defer unlock()
+	if s == nil {
+		return m
+	}
+
+	if n := len(s.pending); n > 0 && s.pending[n-1].message.Offset >= message.Offset {
+		// kafka partition is fetched again from the committed offset after rebalance,
+		// messages fetched before will be handled again, so they aren't tracked anymore
+		for _, old := range s.pending {
+			old.tracked = false
+			t.settle(old)
+		}
+		s.pending = nil
+	}
+	s.pending = append(s.pending, m)
+	return m
+}
+
+// Skip reports whether the message isn't tracked anymore, so it shouldn't be handled.
+func (t *ackTracker) Skip(m *pendingMessage) bool {
+	_, unlock := t.lock(m.message)
+	defer unlock()
+	if m.tracked {
+		return false
+	}
+	m.inLane = false
+	t.settle(m)
+	return true
+}
+
+// Abandon returns the message left unhandled on shutdown to the broker. Kafka offset isn't committed,
+// so the message is fetched again after restart.
+func (t *ackTracker) Abandon(ctx context.Context, m *pendingMessage) {
+	s, unlock := t.lock(m.message)
+	m.inLane = false
+	if s == nil {
+		m.tracked = false
+	}
+	t.settle(m)
+	unlock()
+
+	if err := t.consumer.Nack(context.WithoutCancel(ctx), m.message); err != nil {
+		t.logger.Errorf("can't return message %s/%d/%d to the broker: %v",
+			m.message.Topic, m.message.Partition, m.message.Offset, err)
+	}
+}
+
+// Done marks the message as handled and acknowledges the handled prefix of the stream.
+func (t *ackTracker) Done(ctx context.Context, m *pendingMessage) {
+	s, unlock := t.lock(m.message)
+	defer unlock()
+	m.inLane = false
+	if !m.tracked {
+		t.settle(m)
+		return
+	}
+	m.done = true
+
+	handled := []*pendingMessage{m}
+	if s != nil {
+		handled = nil
+		for len(s.pending) > 0 && s.pending[0].done {
+			handled = append(handled, s.pending[0])
+			s.pending = s.pending[1:]
+		}
+		if len(handled) == 0 {
+			return
+		}
+	}
+
+	// acknowledging under the stream lock, so messages of the stream are acknowledged in order
+	messages := make([]Message, 0, len(handled))
+	for _, m := range handled {
+		messages = append(messages, m.message)
+	}
+	err := t.consumer.Ack(ctx, messages...)
+	for _, m := range handled {
+		m.tracked = false
+		t.settle(m)
+	}
+	if err != nil {
+		last := messages[len(messages)-1]
+		t.logger.Errorf("can't acknowledge message %s/%d/%d: %v", last.Topic, last.Partition, last.Offset, err)
+		return
+	}
+
+	for _, m := range handled {
+		for _, hook := range m.hooks {
+			hook()
+		}
+	}
+}
+
+// sleep waits for d, returns false if ctx is done before.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
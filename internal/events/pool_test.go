@@ -0,0 +1,459 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Falokut/email_service/internal/health"
+	"github.com/sirupsen/logrus"
+)
+
+// fakeConsumer returns the messages one by one, then blocks until ctx is done, and records acks and nacks.
+type fakeConsumer struct {
+	mu      sync.Mutex
+	queued  []Message
+	fetched int
+	acked   []string
+	nacked  []string
+	ackErr  error
+}
+
+func (c *fakeConsumer) Fetch(ctx context.Context) (Message, error) {
+	c.mu.Lock()
+	if len(c.queued) > 0 {
+		message := c.queued[0]
+		c.queued = c.queued[1:]
+		c.fetched++
+		c.mu.Unlock()
+		return message, nil
+	}
+	c.mu.Unlock()
+	<-ctx.Done()
+	return Message{}, ctx.Err()
+}
+
+func (c *fakeConsumer) Ack(ctx context.Context, messages ...Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ackErr != nil {
+		return c.ackErr
+	}
+	for _, m := range messages {
+		c.acked = append(c.acked, messageID(m))
+	}
+	return nil
+}
+
+func (c *fakeConsumer) Nack(ctx context.Context, messages ...Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, m := range messages {
+		c.nacked = append(c.nacked, messageID(m))
+	}
+	return nil
+}
+
+func (c *fakeConsumer) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (c *fakeConsumer) Close() error {
+	return nil
+}
+
+func (c *fakeConsumer) acks() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.acked...)
+}
+
+func (c *fakeConsumer) nacks() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.nacked...)
+}
+
+func messageID(m Message) string {
+	return fmt.Sprintf("%s/%d/%d", m.Topic, m.Partition, m.Offset)
+}
+
+// testMessage makes the kafka like message, the partition is the stream of the message.
+func testMessage(partition int, offset int64) Message {
+	return Message{
+		Topic:     "orders",
+		Stream:    fmt.Sprintf("orders/%d", partition),
+		Partition: partition,
+		Offset:    offset,
+	}
+}
+
+// unorderedMessage makes the message without stream, like NATS and AMQP messages.
+func unorderedMessage(offset int64) Message {
+	return Message{Topic: "orders", Offset: offset}
+}
+
+func TestAckTracker(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		// add starts tracking of the message in the partition 0 with the offset
+		steps        func(tr *ackTracker, add func(offset int64) *pendingMessage)
+		ackErr       error
+		wantAcks     []string
+		wantNacks    []string
+		wantReleased int
+		wantHooks    int
+	}{
+		{
+			name: "in order",
+			steps: func(tr *ackTracker, add func(offset int64) *pendingMessage) {
+				first, second := add(0), add(1)
+				tr.Done(ctx, first)
+				tr.Done(ctx, second)
+			},
+			wantAcks:     []string{"orders/0/0", "orders/0/1"},
+			wantReleased: 2,
+			wantHooks:    2,
+		},
+		{
+			name: "contiguous prefix",
+			steps: func(tr *ackTracker, add func(offset int64) *pendingMessage) {
+				m := []*pendingMessage{add(0), add(1), add(2), add(3)}
+				tr.Done(ctx, m[2])
+				tr.Done(ctx, m[1])
+				tr.Done(ctx, m[0])
+			},
+			// handled prefix is acknowledged at once, message 3 isn't handled
+			wantAcks:     []string{"orders/0/0", "orders/0/1", "orders/0/2"},
+			wantReleased: 3,
+			wantHooks:    3,
+		},
+		{
+			name: "streams are independent",
+			steps: func(tr *ackTracker, add func(offset int64) *pendingMessage) {
+				first, second := add(0), add(1)
+				other := tr.Add(testMessage(1, 0))
+				tr.Done(ctx, second)
+				tr.Done(ctx, other)
+				if !reflect.DeepEqual(tr.consumer.(*fakeConsumer).acks(), []string{"orders/1/0"}) {
+					t.Error("stream is blocked by the other one")
+				}
+				tr.Done(ctx, first)
+			},
+			wantAcks:     []string{"orders/1/0", "orders/0/0", "orders/0/1"},
+			wantReleased: 3,
+			// message of the other stream is added without the hook
+			wantHooks: 2,
+		},
+		{
+			name: "without stream",
+			steps: func(tr *ackTracker, add func(offset int64) *pendingMessage) {
+				first, second := tr.Add(unorderedMessage(0)), tr.Add(unorderedMessage(1))
+				tr.Done(ctx, second)
+				tr.Done(ctx, first)
+			},
+			// messages without stream don't wait for each other
+			wantAcks:     []string{"orders/0/1", "orders/0/0"},
+			wantReleased: 2,
+		},
+		{
+			name: "fetched again after rebalance",
+			steps: func(tr *ackTracker, add func(offset int64) *pendingMessage) {
+				first, second := add(0), add(1)
+				tr.Done(ctx, second)
+				again := add(0)
+				// handled message isn't tracked anymore, so it's released on the fetch,
+				// message in the lane is released when it's skipped
+				if !tr.Skip(first) {
+					t.Error("message fetched before the rebalance isn't skipped")
+				}
+				if tr.Skip(again) {
+					t.Error("message fetched again is skipped")
+				}
+				tr.Done(ctx, again)
+			},
+			wantAcks:     []string{"orders/0/0"},
+			wantReleased: 3,
+			wantHooks:    1,
+		},
+		{
+			name: "abandoned",
+			steps: func(tr *ackTracker, add func(offset int64) *pendingMessage) {
+				first, second := add(0), add(1)
+				tr.Done(ctx, second)
+				tr.Abandon(ctx, first)
+			},
+			// messages of the stream stay pending until shutdown, so they are fetched again after restart
+			wantNacks:    []string{"orders/0/0"},
+			wantReleased: 0,
+		},
+		{
+			name: "abandoned without stream",
+			steps: func(tr *ackTracker, add func(offset int64) *pendingMessage) {
+				tr.Abandon(ctx, tr.Add(unorderedMessage(0)))
+			},
+			wantNacks:    []string{"orders/0/0"},
+			wantReleased: 1,
+		},
+		{
+			name: "ack error",
+			steps: func(tr *ackTracker, add func(offset int64) *pendingMessage) {
+				tr.Done(ctx, add(0))
+			},
+			ackErr: errors.New("rebalance in progress"),
+			// kafka offset is committed with the next one, hooks aren't called
+			wantReleased: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			consumer := &fakeConsumer{ackErr: tt.ackErr}
+			released, hooks := 0, 0
+			tr := newAckTracker(consumer, logrus.New(), func() { released++ })
+
+			tt.steps(tr, func(offset int64) *pendingMessage {
+				m := tr.Add(testMessage(0, offset))
+				onAck(withPendingMessage(ctx, m), func() { hooks++ })
+				return m
+			})
+
+			if got := consumer.acks(); !reflect.DeepEqual(got, tt.wantAcks) {
+				t.Errorf("got acks %v, want %v", got, tt.wantAcks)
+			}
+			if got := consumer.nacks(); !reflect.DeepEqual(got, tt.wantNacks) {
+				t.Errorf("got nacks %v, want %v", got, tt.wantNacks)
+			}
+			if released != tt.wantReleased {
+				t.Errorf("got %d released slots, want %d", released, tt.wantReleased)
+			}
+			if hooks != tt.wantHooks {
+				t.Errorf("got %d ack hooks called, want %d", hooks, tt.wantHooks)
+			}
+		})
+	}
+}
+
+func TestWorkerPoolLane(t *testing.T) {
+	byPartition := newWorkerPool(PoolConfig{Workers: 4}, nil, nil, logrus.New(), nil)
+	byKey := newWorkerPool(PoolConfig{Workers: 4, OrderBy: "key"}, nil, nil, logrus.New(), nil)
+
+	withKey := func(m Message, key string) Message {
+		m.Key = []byte(key)
+		return m
+	}
+	first := withKey(testMessage(1, 0), "order-1")
+	samePartition := withKey(testMessage(1, 1), "order-2")
+	sameKey := withKey(testMessage(2, 0), "order-1")
+	if byPartition.lane(first) != byPartition.lane(samePartition) {
+		t.Error("messages of the same partition are in the different lanes")
+	}
+	if byKey.lane(first) != byKey.lane(sameKey) {
+		t.Error("messages with the same key are in the different lanes")
+	}
+
+	lanes := make(map[int]bool)
+	for partition := 0; partition < 32; partition++ {
+		lanes[byPartition.lane(testMessage(partition, 0))] = true
+	}
+	if len(lanes) != 4 {
+		t.Errorf("partitions use %d lanes, want all the 4 workers", len(lanes))
+	}
+}
+
+func TestPoolConfigDefaults(t *testing.T) {
+	cfg := PoolConfig{Workers: 3, OrderBy: "unknown"}.withDefaults()
+	want := PoolConfig{Workers: 3, MaxInFlight: 30, OrderBy: orderByPartition}
+	if cfg != want {
+		t.Errorf("got %+v, want %+v", cfg, want)
+	}
+}
+
+// runPool runs the pool until stop is called, stop waits for Run to return.
+func runPool(p *workerPool) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.Run(ctx)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// waitFor polls cond until it's true or the test times out.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWorkerPoolOrder(t *testing.T) {
+	var messages []Message
+	for offset := int64(0); offset < 20; offset++ {
+		for partition := 0; partition < 3; partition++ {
+			messages = append(messages, testMessage(partition, offset))
+		}
+	}
+	consumer := &fakeConsumer{queued: messages}
+
+	var mu sync.Mutex
+	handled := make(map[int][]int64)
+	handle := func(ctx context.Context, message Message, loop *health.Loop) error {
+		mu.Lock()
+		defer mu.Unlock()
+		handled[message.Partition] = append(handled[message.Partition], message.Offset)
+		return nil
+	}
+	stop := runPool(newWorkerPool(PoolConfig{Workers: 4, MaxInFlight: 5}, consumer, handle, logrus.New(), nil))
+	waitFor(t, "acks", func() bool {
+		acks := consumer.acks()
+		last := make(map[string]bool)
+		for _, c := range acks {
+			last[c] = true
+		}
+		return last["orders/0/19"] && last["orders/1/19"] && last["orders/2/19"]
+	})
+	stop()
+
+	for partition, offsets := range handled {
+		for i, offset := range offsets {
+			if offset != int64(i) {
+				t.Fatalf("partition %d handled in order %v", partition, offsets)
+			}
+		}
+	}
+}
+
+func TestWorkerPoolBackPressure(t *testing.T) {
+	consumer := &fakeConsumer{queued: []Message{
+		testMessage(0, 0), testMessage(0, 1), testMessage(0, 2), testMessage(0, 3),
+	}}
+	unblock := make(chan struct{})
+	handle := func(ctx context.Context, message Message, loop *health.Loop) error {
+		<-unblock
+		return nil
+	}
+	stop := runPool(newWorkerPool(PoolConfig{Workers: 2, MaxInFlight: 2}, consumer, handle, logrus.New(), nil))
+	defer stop()
+
+	waitFor(t, "fetch", func() bool {
+		consumer.mu.Lock()
+		defer consumer.mu.Unlock()
+		return consumer.fetched == 2
+	})
+	// reader doesn't fetch while the max in flight messages aren't acknowledged
+	time.Sleep(20 * time.Millisecond)
+	consumer.mu.Lock()
+	fetched := consumer.fetched
+	consumer.mu.Unlock()
+	if fetched != 2 {
+		t.Errorf("got %d fetched messages, want 2", fetched)
+	}
+
+	close(unblock)
+	waitFor(t, "all acks", func() bool {
+		acks := consumer.acks()
+		return len(acks) > 0 && acks[len(acks)-1] == "orders/0/3"
+	})
+}
+
+func TestWorkerPoolHandlesFailedMessageAgain(t *testing.T) {
+	minHandleBackoff, maxHandleBackoff = time.Millisecond, time.Millisecond
+	t.Cleanup(func() { minHandleBackoff, maxHandleBackoff = time.Second, time.Minute })
+
+	probes := health.NewServer(health.Config{StallTimeout: time.Nanosecond}, logrus.New())
+	loop := probes.Loop("orders_events")
+	alive := func() bool {
+		rec := httptest.NewRecorder()
+		probes.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		return rec.Code == http.StatusOK
+	}
+
+	consumer := &fakeConsumer{queued: []Message{testMessage(0, 0), testMessage(0, 1), testMessage(0, 2)}}
+	var (
+		mu       sync.Mutex
+		handled  []string
+		attempts int
+	)
+	handle := func(ctx context.Context, message Message, loop *health.Loop) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if message.Offset == 1 {
+			attempts++
+			if attempts > 1 && alive() {
+				t.Error("loop is alive while the message is failing")
+			}
+			if attempts < 3 {
+				// message 2 isn't handled and message 1 isn't acknowledged while it's failing
+				if acks := consumer.acks(); len(acks) != 1 || acks[0] != "orders/0/0" {
+					t.Errorf("got acks %v while message 1 is failing, want [orders/0/0]", acks)
+				}
+				return errors.New("retry topic is unavailable")
+			}
+		}
+		if message.Offset == 2 && !alive() {
+			t.Error("loop isn't alive after the failed message is handled")
+		}
+		handled = append(handled, messageID(message))
+		return nil
+	}
+	stop := runPool(newWorkerPool(PoolConfig{Workers: 2}, consumer, handle, logrus.New(), loop))
+	waitFor(t, "acks", func() bool {
+		acks := consumer.acks()
+		return len(acks) > 0 && acks[len(acks)-1] == "orders/0/2"
+	})
+	stop()
+
+	if want := []string{"orders/0/0", "orders/0/1", "orders/0/2"}; !reflect.DeepEqual(handled, want) {
+		t.Errorf("got handled %v, want %v", handled, want)
+	}
+	if attempts != 3 {
+		t.Errorf("failed message is handled %d times, want 3", attempts)
+	}
+}
+
+func TestWorkerPoolShutdown(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		wantAcks  []string
+		wantNacks []string
+	}{
+		// message handled during the shutdown is acknowledged, so it isn't sent again after restart
+		{name: "handled", wantAcks: []string{"orders/0/0"}},
+		// unhandled message is returned to the broker
+		{name: "failed", err: errors.New("canceled"), wantNacks: []string{"orders/0/0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			consumer := &fakeConsumer{queued: []Message{testMessage(0, 0)}}
+			ctx, cancel := context.WithCancel(context.Background())
+			handle := func(ctx context.Context, message Message, loop *health.Loop) error {
+				cancel()
+				return tt.err
+			}
+			newWorkerPool(PoolConfig{}, consumer, handle, logrus.New(), nil).Run(ctx)
+
+			if got := consumer.acks(); !reflect.DeepEqual(got, tt.wantAcks) {
+				t.Errorf("got acks %v, want %v", got, tt.wantAcks)
+			}
+			if got := consumer.nacks(); !reflect.DeepEqual(got, tt.wantNacks) {
+				t.Errorf("got nacks %v, want %v", got, tt.wantNacks)
+			}
+		})
+	}
+}
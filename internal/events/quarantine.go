@@ -0,0 +1,41 @@
+package events
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/Falokut/email_service/pkg/logging"
+	"github.com/sirupsen/logrus"
+)
+
+const validationErrorHeader = "x-validation-error"
+
+func quarantineTopic(topic string) string {
+	return topic + ".quarantine"
+}
+
+// Quarantine forwards the event with invalid structure into the quarantine topic
+// with the raw value and the validation error.
+func (r *retrier) Quarantine(ctx context.Context, message Message, cause error) error {
+	topic := originalTopic(message)
+	handledEvents.WithLabelValues(topic, resultSkipped).Inc()
+
+	logging.FromContext(ctx, r.logger).WithFields(logrus.Fields{
+		"key":              string(message.Key),
+		"validation_error": cause.Error(),
+	}).Warn("invalid event quarantined")
+
+	headers := copyHeaders(message.Headers)
+	if _, ok := header(message, originalTopicHeader); !ok {
+		headers[originalTopicHeader] = message.Topic
+		headers[originalPartitionHeader] = strconv.Itoa(message.Partition)
+		headers[originalOffsetHeader] = strconv.FormatInt(message.Offset, 10)
+	}
+	headers[validationErrorHeader] = cause.Error()
+
+	return r.publisher.Publish(ctx, quarantineTopic(topic), Message{
+		Key:     message.Key,
+		Value:   message.Value,
+		Headers: headers,
+	})
+}
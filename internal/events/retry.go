@@ -0,0 +1,203 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/Falokut/email_service/internal/models"
+	"github.com/Falokut/email_service/pkg/logging"
+	"github.com/sirupsen/logrus"
+)
+
+type RetryConfig struct {
+	// number of processing attempts before message moved to the dead letter topic
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+}
+
+const (
+	defaultMaxAttempts    = 5
+	defaultInitialBackoff = 10 * time.Second
+	defaultMaxBackoff     = 10 * time.Minute
+	defaultMultiplier     = 2
+)
+
+const (
+	originalTopicHeader     = "x-original-topic"
+	originalPartitionHeader = "x-original-partition"
+	originalOffsetHeader    = "x-original-offset"
+	originalTimeHeader      = "x-original-time"
+	attemptHeader           = "x-attempt"
+	retryAtHeader           = "x-retry-at"
+	lastErrorHeader         = "x-last-error"
+)
+
+func retryTopic(topic string, attempt int) string {
+	return fmt.Sprintf("%s.retry.%d", topic, attempt)
+}
+
+func deadLetterTopic(topic string) string {
+	return topic + ".dlq"
+}
+
+// retrier moves failed messages into the delayed retry topics and,
+// once attempts exhausted, into the dead letter topic.
+// Message failed on attempt n goes to the topic.retry.n
+type retrier struct {
+	publisher Publisher
+	cfg       RetryConfig
+	logger    *logrus.Logger
+}
+
+func newRetrier(publisher Publisher, cfg RetryConfig, logger *logrus.Logger) *retrier {
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultMaxAttempts
+	}
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = defaultInitialBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = defaultMaxBackoff
+	}
+	if cfg.Multiplier < 1 {
+		cfg.Multiplier = defaultMultiplier
+	}
+
+	return &retrier{
+		publisher: publisher,
+		cfg:       cfg,
+		logger:    logger,
+	}
+}
+
+// RetryTopics returns retry topics, which should be consumed for the specified topics.
+func (r *retrier) RetryTopics(topics ...string) []string {
+	var retryTopics []string
+	for _, topic := range topics {
+		for attempt := 1; attempt < r.cfg.MaxAttempts; attempt++ {
+			retryTopics = append(retryTopics, retryTopic(topic, attempt))
+		}
+	}
+	return retryTopics
+}
+
+func (r *retrier) backoff(attempt int) time.Duration {
+	backoff := float64(r.cfg.InitialBackoff) * math.Pow(r.cfg.Multiplier, float64(attempt-1))
+	if backoff > float64(r.cfg.MaxBackoff) {
+		return r.cfg.MaxBackoff
+	}
+	return time.Duration(backoff)
+}
+
+// Retry schedules redelivery of the failed message or moves it into the dead letter topic,
+// if the cause is permanent or attempts exhausted.
+func (r *retrier) Retry(ctx context.Context, message Message, cause error) error {
+	topic := originalTopic(message)
+	attempt := messageAttempt(message)
+
+	headers := copyHeaders(message.Headers)
+	if _, ok := header(message, originalTopicHeader); !ok {
+		headers[originalTopicHeader] = message.Topic
+		headers[originalPartitionHeader] = strconv.Itoa(message.Partition)
+		headers[originalOffsetHeader] = strconv.FormatInt(message.Offset, 10)
+		headers[originalTimeHeader] = message.Time.Format(time.RFC3339Nano)
+	}
+	headers[lastErrorHeader] = cause.Error()
+
+	next := Message{
+		Key:     message.Key,
+		Value:   message.Value,
+		Headers: headers,
+	}
+	var nextTopic string
+	if models.IsPermanent(cause) || attempt >= r.cfg.MaxAttempts {
+		nextTopic = deadLetterTopic(topic)
+		headers[attemptHeader] = strconv.Itoa(attempt)
+		logging.FromContext(ctx, r.logger).WithFields(logrus.Fields{
+			"attempt": attempt,
+			"error":   cause.Error(),
+		}).Warn("moving message to the dead letter topic")
+	} else {
+		nextTopic = retryTopic(topic, attempt)
+		headers[retryAtHeader] = time.Now().Add(r.backoff(attempt)).Format(time.RFC3339Nano)
+		headers[attemptHeader] = strconv.Itoa(attempt + 1)
+	}
+
+	return r.publisher.Publish(ctx, nextTopic, next)
+}
+
+// Wait blocks until the retry message is due.
+// Messages in each retry topic have the same delay, so they are due in order of arrival.
+func (r *retrier) Wait(ctx context.Context, message Message) error {
+	retryAt, ok := retryTime(message)
+	if !ok {
+		return nil
+	}
+
+	timer := time.NewTimer(time.Until(retryAt))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (r *retrier) Close() error {
+	return r.publisher.Close()
+}
+
+// retryTime returns the time when the retry message is due.
+func retryTime(message Message) (time.Time, bool) {
+	value, ok := header(message, retryAtHeader)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	retryAt, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return retryAt, true
+}
+
+// originalTopic returns the topic in which the message was originally published.
+func originalTopic(message Message) string {
+	if topic, ok := header(message, originalTopicHeader); ok {
+		return topic
+	}
+	return message.Topic
+}
+
+// originalTime returns the time when the message was originally published.
+func originalTime(message Message) time.Time {
+	value, ok := header(message, originalTimeHeader)
+	if !ok {
+		return message.Time
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return message.Time
+	}
+	return t
+}
+
+func messageAttempt(message Message) int {
+	value, ok := header(message, attemptHeader)
+	if !ok {
+		return 1
+	}
+
+	attempt, err := strconv.Atoi(value)
+	if err != nil || attempt < 1 {
+		return 1
+	}
+	return attempt
+}
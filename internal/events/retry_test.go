@@ -0,0 +1,209 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Falokut/email_service/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+func newTestRetrier(t *testing.T, cfg RetryConfig) (*retrier, *MemoryBroker) {
+	t.Helper()
+	broker := NewMemoryBroker()
+	publisher, err := broker.Publisher(ConsumerConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newRetrier(publisher, cfg, logrus.New()), broker
+}
+
+// singleQueued returns the only message queued into the topic.
+func singleQueued(t *testing.T, broker *MemoryBroker, topic string) Message {
+	t.Helper()
+	queued := broker.Queued(topic)
+	if len(queued) != 1 {
+		t.Fatalf("%d messages queued into %s, want 1", len(queued), topic)
+	}
+	return queued[0]
+}
+
+func TestRetrierRetryTopics(t *testing.T) {
+	tests := []struct {
+		maxAttempts int
+		want        []string
+	}{
+		{maxAttempts: 1, want: nil},
+		{maxAttempts: 3, want: []string{"orders.retry.1", "orders.retry.2"}},
+	}
+	for _, tt := range tests {
+		r, _ := newTestRetrier(t, RetryConfig{MaxAttempts: tt.maxAttempts})
+		got := r.RetryTopics("orders")
+		if len(got) != len(tt.want) {
+			t.Fatalf("max attempts %d: retry topics %v, want %v", tt.maxAttempts, got, tt.want)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("max attempts %d: retry topics %v, want %v", tt.maxAttempts, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestRetrierRetry(t *testing.T) {
+	r, broker := newTestRetrier(t, RetryConfig{MaxAttempts: 3, InitialBackoff: time.Minute})
+	original := Message{
+		Topic:     "orders",
+		Key:       []byte("order-1"),
+		Value:     []byte(`{"order":{}}`),
+		Headers:   map[string]string{"event_type": "order_created"},
+		Time:      time.Now().Add(-time.Hour),
+		Partition: 2,
+		Offset:    42,
+	}
+
+	before := time.Now()
+	if err := r.Retry(context.Background(), original, errors.New("smtp is down")); err != nil {
+		t.Fatal(err)
+	}
+
+	retry := singleQueued(t, broker, retryTopic("orders", 1))
+	if string(retry.Key) != "order-1" || string(retry.Value) != `{"order":{}}` {
+		t.Errorf("retry key %q and value %q aren't copied", retry.Key, retry.Value)
+	}
+	wantHeaders := map[string]string{
+		"event_type":            "order_created",
+		originalTopicHeader:     "orders",
+		originalPartitionHeader: "2",
+		originalOffsetHeader:    "42",
+		attemptHeader:           "2",
+		lastErrorHeader:         "smtp is down",
+	}
+	for k, want := range wantHeaders {
+		if got := retry.Headers[k]; got != want {
+			t.Errorf("header %s = %q, want %q", k, got, want)
+		}
+	}
+	if original.Headers[attemptHeader] != "" {
+		t.Error("headers of the original message are changed")
+	}
+
+	retryAt, ok := retryTime(retry)
+	if !ok {
+		t.Fatal("retry time isn't set")
+	}
+	if retryAt.Before(before.Add(time.Minute)) || retryAt.After(time.Now().Add(time.Minute)) {
+		t.Errorf("retry time %s, want initial backoff after %s", retryAt, before)
+	}
+	if !originalTime(retry).Equal(original.Time) {
+		t.Errorf("original time %s, want %s", originalTime(retry), original.Time)
+	}
+
+	// second failure goes into the next retry topic and keeps the original position
+	retry.Headers[retryAtHeader] = time.Now().Format(time.RFC3339Nano)
+	if err := r.Retry(context.Background(), retry, errors.New("smtp is still down")); err != nil {
+		t.Fatal(err)
+	}
+	next := singleQueued(t, broker, retryTopic("orders", 2))
+	if next.Headers[attemptHeader] != "3" || next.Headers[originalOffsetHeader] != "42" {
+		t.Errorf("attempt %s, original offset %s, want 3 and 42",
+			next.Headers[attemptHeader], next.Headers[originalOffsetHeader])
+	}
+}
+
+func TestRetrierDeadLetter(t *testing.T) {
+	tests := []struct {
+		name    string
+		attempt int
+		cause   error
+	}{
+		{name: "attempts exhausted", attempt: 3, cause: errors.New("smtp is down")},
+		{name: "permanent error", attempt: 1, cause: models.Error(models.Rejected, "mailbox unavailable")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, broker := newTestRetrier(t, RetryConfig{MaxAttempts: 3})
+			message := Message{
+				Topic:   "orders",
+				Value:   []byte("{}"),
+				Headers: map[string]string{attemptHeader: strconv.Itoa(tt.attempt)},
+			}
+			if err := r.Retry(context.Background(), message, tt.cause); err != nil {
+				t.Fatal(err)
+			}
+
+			dead := singleQueued(t, broker, deadLetterTopic("orders"))
+			if dead.Headers[attemptHeader] != strconv.Itoa(tt.attempt) {
+				t.Errorf("attempt %s, want %d", dead.Headers[attemptHeader], tt.attempt)
+			}
+			if dead.Headers[lastErrorHeader] != tt.cause.Error() {
+				t.Errorf("last error %q, want %q", dead.Headers[lastErrorHeader], tt.cause.Error())
+			}
+			if _, ok := retryTime(dead); ok {
+				t.Error("dead letter has the retry time")
+			}
+			for _, topic := range r.RetryTopics("orders") {
+				if queued := broker.Queued(topic); len(queued) != 0 {
+					t.Errorf("%d messages queued into %s", len(queued), topic)
+				}
+			}
+		})
+	}
+}
+
+func TestRetrierQuarantine(t *testing.T) {
+	r, broker := newTestRetrier(t, RetryConfig{})
+	message := Message{
+		Topic:     "orders",
+		Key:       []byte("order-1"),
+		Value:     []byte("not json"),
+		Partition: 1,
+		Offset:    7,
+	}
+	cause := models.Error(models.InvalidArgument, "email is empty")
+	if err := r.Quarantine(context.Background(), message, cause); err != nil {
+		t.Fatal(err)
+	}
+
+	quarantined := singleQueued(t, broker, quarantineTopic("orders"))
+	if string(quarantined.Value) != "not json" {
+		t.Errorf("quarantined value %q, want the raw value", quarantined.Value)
+	}
+	wantHeaders := map[string]string{
+		originalTopicHeader:     "orders",
+		originalPartitionHeader: "1",
+		originalOffsetHeader:    "7",
+		validationErrorHeader:   cause.Error(),
+	}
+	for k, want := range wantHeaders {
+		if got := quarantined.Headers[k]; got != want {
+			t.Errorf("header %s = %q, want %q", k, got, want)
+		}
+	}
+	if queued := broker.Queued(deadLetterTopic("orders")); len(queued) != 0 {
+		t.Errorf("%d messages queued into the dead letter topic", len(queued))
+	}
+}
+
+func TestRetrierWait(t *testing.T) {
+	r, _ := newTestRetrier(t, RetryConfig{})
+
+	due := Message{Headers: map[string]string{retryAtHeader: time.Now().Add(50 * time.Millisecond).Format(time.RFC3339Nano)}}
+	start := time.Now()
+	if err := r.Wait(context.Background(), due); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("waited %s, want until the retry time", elapsed)
+	}
+
+	later := Message{Headers: map[string]string{retryAtHeader: time.Now().Add(time.Hour).Format(time.RFC3339Nano)}}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := r.Wait(ctx, later); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("wait error %v, want deadline exceeded", err)
+	}
+}
@@ -0,0 +1,119 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Falokut/email_service/internal/service"
+)
+
+// handlers of the routed events
+const (
+	// event with the order, handled by the orders events consumer
+	OrderCreatedHandler = "ORDER_CREATED"
+	// event with the token link, handled by the tokens delivery requests consumer
+	TokenHandler = "TOKEN"
+)
+
+// header with the event type, takes precedence over the type field of the event
+const eventTypeHeader = "x-event-type"
+
+type Route struct {
+	Topic string
+	// if not empty, route matches only events of this type,
+	// type is taken from the x-event-type header or from the type field of the event
+	Type     string
+	Handler  string
+	Subject  string
+	Template string
+}
+
+// RoutingTable maps topics and event types to the handlers and the mail templates.
+type RoutingTable struct {
+	routes []Route
+}
+
+func NewRoutingTable(routes []Route) (*RoutingTable, error) {
+	handlers := make(map[string]string, len(routes))
+	seen := make(map[string]bool, len(routes))
+	normalized := make([]Route, 0, len(routes))
+	for _, r := range routes {
+		r.Handler = strings.ToUpper(r.Handler)
+		switch {
+		case r.Topic == "":
+			return nil, fmt.Errorf("route topic is empty")
+		case r.Handler != OrderCreatedHandler && r.Handler != TokenHandler:
+			return nil, fmt.Errorf("unsupported route handler %q for the topic %s", r.Handler, r.Topic)
+		case r.Template == "":
+			return nil, fmt.Errorf("route template for the topic %s is empty", r.Topic)
+		}
+
+		// consumers of different handlers would share partitions of the topic, so topic belongs to one handler
+		if h, ok := handlers[r.Topic]; ok && h != r.Handler {
+			return nil, fmt.Errorf("topic %s is routed to the different handlers %s and %s", r.Topic, h, r.Handler)
+		}
+		handlers[r.Topic] = r.Handler
+
+		key := r.Topic + "/" + r.Type
+		if seen[key] {
+			return nil, fmt.Errorf("duplicate route for the topic %s and type %q", r.Topic, r.Type)
+		}
+		seen[key] = true
+		normalized = append(normalized, r)
+	}
+	return &RoutingTable{routes: normalized}, nil
+}
+
+// Topics returns topics routed to the handler.
+func (t *RoutingTable) Topics(handler string) []string {
+	var topics []string
+	seen := make(map[string]bool)
+	for _, r := range t.routes {
+		if r.Handler == handler && !seen[r.Topic] {
+			seen[r.Topic] = true
+			topics = append(topics, r.Topic)
+		}
+	}
+	return topics
+}
+
+// Match returns the route for the event type from the topic, route with the matching type
+// takes precedence over the route of the whole topic.
+func (t *RoutingTable) Match(topic, eventType string) (Route, bool) {
+	var fallback *Route
+	for i, r := range t.routes {
+		if r.Topic != topic {
+			continue
+		}
+		if r.Type == "" {
+			fallback = &t.routes[i]
+		} else if r.Type == eventType {
+			return r, true
+		}
+	}
+	if fallback != nil {
+		return *fallback, true
+	}
+	return Route{}, false
+}
+
+func (r Route) MailTemplate() service.MailTemplate {
+	return service.MailTemplate{Subject: r.Subject, Name: r.Template}
+}
+
+// eventType returns the type of the event from the x-event-type header or from the type field of the event,
+// if there is no type, the original topic is used.
+func eventType(message Message) string {
+	if t, ok := header(message, eventTypeHeader); ok && t != "" {
+		return t
+	}
+
+	var typed struct {
+		Type string `json:"type"`
+	}
+	if json.Unmarshal(message.Value, &typed) == nil && typed.Type != "" {
+		return typed.Type
+	}
+	return originalTopic(message)
+}
@@ -0,0 +1,134 @@
+package events
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewRoutingTableErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		routes  []Route
+		wantErr string
+	}{
+		{
+			name:    "empty topic",
+			routes:  []Route{{Handler: TokenHandler, Template: "accountActivation.html"}},
+			wantErr: "route topic is empty",
+		},
+		{
+			name:    "unknown handler",
+			routes:  []Route{{Topic: "orders", Handler: "REFUND", Template: "refund.html"}},
+			wantErr: `unsupported route handler "REFUND"`,
+		},
+		{
+			name:    "missing template",
+			routes:  []Route{{Topic: "orders", Handler: OrderCreatedHandler}},
+			wantErr: "route template for the topic orders is empty",
+		},
+		{
+			name: "duplicate route",
+			routes: []Route{
+				{Topic: "orders", Type: "created", Handler: OrderCreatedHandler, Template: "order.html"},
+				{Topic: "orders", Type: "created", Handler: OrderCreatedHandler, Template: "other.html"},
+			},
+			wantErr: `duplicate route for the topic orders and type "created"`,
+		},
+		{
+			name: "duplicate topic route",
+			routes: []Route{
+				{Topic: "orders", Handler: OrderCreatedHandler, Template: "order.html"},
+				{Topic: "orders", Handler: OrderCreatedHandler, Template: "other.html"},
+			},
+			wantErr: `duplicate route for the topic orders and type ""`,
+		},
+		{
+			name: "topic of the different handlers",
+			routes: []Route{
+				{Topic: "accounts", Type: "order", Handler: OrderCreatedHandler, Template: "order.html"},
+				{Topic: "accounts", Type: "token", Handler: TokenHandler, Template: "accountActivation.html"},
+			},
+			wantErr: "topic accounts is routed to the different handlers ORDER_CREATED and TOKEN",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRoutingTable(tt.routes)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRoutingTable(t *testing.T) {
+	routes, err := NewRoutingTable([]Route{
+		{Topic: "accounts", Handler: "token", Subject: "Account", Template: "account.html"},
+		{Topic: "accounts", Type: "password_reset", Handler: TokenHandler, Subject: "Password", Template: "password.html"},
+		{Topic: "orders", Handler: OrderCreatedHandler, Subject: "Order", Template: "order.html"},
+		{Topic: "signup", Handler: TokenHandler, Template: "account.html"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := routes.Topics(TokenHandler), []string{"accounts", "signup"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("token topics: got %v, want %v", got, want)
+	}
+	if got, want := routes.Topics(OrderCreatedHandler), []string{"orders"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("order topics: got %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		topic, eventType string
+		wantOk           bool
+		wantTemplate     string
+	}{
+		// route with the matching type takes precedence over the route of the whole topic
+		{topic: "accounts", eventType: "password_reset", wantOk: true, wantTemplate: "password.html"},
+		{topic: "accounts", eventType: "email_verification", wantOk: true, wantTemplate: "account.html"},
+		{topic: "orders", eventType: "orders", wantOk: true, wantTemplate: "order.html"},
+		{topic: "refunds", eventType: "refunds"},
+	}
+	for _, tt := range tests {
+		route, ok := routes.Match(tt.topic, tt.eventType)
+		if ok != tt.wantOk || route.Template != tt.wantTemplate {
+			t.Errorf("match %s/%s: got %t %q, want %t %q", tt.topic, tt.eventType, ok, route.Template, tt.wantOk, tt.wantTemplate)
+		}
+	}
+	if route, _ := routes.Match("accounts", ""); route.Handler != TokenHandler {
+		t.Errorf("got handler %q, want the handler normalized to %q", route.Handler, TokenHandler)
+	}
+}
+
+func TestEventType(t *testing.T) {
+	tests := []struct {
+		name    string
+		message Message
+		want    string
+	}{
+		{
+			name: "header",
+			message: Message{Topic: "accounts", Value: []byte(`{"type":"field"}`),
+				Headers: map[string]string{eventTypeHeader: "header"}},
+			want: "header",
+		},
+		{name: "field", message: Message{Topic: "accounts", Value: []byte(`{"type":"field"}`)}, want: "field"},
+		{name: "topic", message: Message{Topic: "accounts", Value: []byte(`{"email":"user@example.com"}`)}, want: "accounts"},
+		{name: "not json", message: Message{Topic: "accounts", Value: []byte("token")}, want: "accounts"},
+		{
+			name: "original topic of the retried event",
+			message: Message{Topic: retryTopic("accounts", 1), Value: []byte("{}"),
+				Headers: map[string]string{originalTopicHeader: "accounts"}},
+			want: "accounts",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := eventType(tt.message); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -6,105 +6,87 @@ import (
 	"errors"
 	"time"
 
+	"github.com/Falokut/email_service/internal/health"
+	"github.com/Falokut/email_service/internal/idempotency"
 	"github.com/Falokut/email_service/internal/models"
 	"github.com/Falokut/email_service/internal/service"
-	"github.com/segmentio/kafka-go"
+	"github.com/Falokut/email_service/internal/tracing"
+	"github.com/Falokut/email_service/pkg/logging"
 	"github.com/sirupsen/logrus"
 )
 
 type tokensDeliveryRequests struct {
-	reader  *kafka.Reader
-	logger  *logrus.Logger
-	service service.MailService
+	consumer Consumer
+	// consumer of the retry topics, nil if messages aren't retried
+	retryConsumer Consumer
+	retrier       *retrier
+	logger        *logrus.Logger
+	service       service.MailService
+	// handled events records, nil if deduplication is disabled
+	dedup     idempotency.Store
+	routes    *RoutingTable
+	pool      *workerPool
+	retryPool *workerPool
 }
 
-const (
-	emailVerificationTopic = "email_verification_delivery_request"
-	passwordChangeTopic    = "password_change_delivery_request"
-)
-
 func NewTokensDeliveryRequestsConsumer(
-	cfg KafkaReaderConfig,
+	cfg ConsumerConfig,
+	broker Broker,
 	logger *logrus.Logger,
-	service service.MailService) *tokensDeliveryRequests {
-	r := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:          cfg.Brokers,
-		GroupTopics:      []string{emailVerificationTopic, passwordChangeTopic},
-		GroupID:          cfg.GroupID,
-		Logger:           logger,
-		ReadBatchTimeout: cfg.ReadBatchTimeout,
-	})
-
-	return &tokensDeliveryRequests{
-		reader:  r,
-		logger:  logger,
-		service: service,
+	service service.MailService,
+	probes *health.Server,
+	dedup idempotency.Store,
+	routes *RoutingTable) (*tokensDeliveryRequests, error) {
+	publisher, err := broker.Publisher(cfg)
+	if err != nil {
+		return nil, err
 	}
-}
+	retrier := newRetrier(publisher, cfg.Retry, logger)
 
-func (c *tokensDeliveryRequests) Run(ctx context.Context) {
-	for {
-		select {
-		default:
-			c.Consume(ctx)
-		case <-ctx.Done():
-			c.logger.Info("tokens delivery consumer shutting down")
-			c.reader.Close()
-			c.logger.Info("tokens delivery consumer shutted down")
-			return
-		}
+	topics := routes.Topics(TokenHandler)
+	consumer, err := broker.Consumer("tokens_delivery_requests", cfg, topics)
+	if err != nil {
+		retrier.Close()
+		return nil, err
 	}
-}
-
-func (e *tokensDeliveryRequests) Shutdown() error {
-	return e.reader.Close()
-}
-
-func (e *tokensDeliveryRequests) handleError(ctx context.Context, err *error) {
-	if ctx.Err() != nil {
-		var code models.ErrorCode
-		switch {
-		case errors.Is(ctx.Err(), context.Canceled):
-			code = models.Canceled
-		case errors.Is(ctx.Err(), context.DeadlineExceeded):
-			code = models.DeadlineExceeded
-		}
-		*err = models.Error(code, ctx.Err().Error())
-		return
+	retryConsumer, err := newRetryConsumer(broker, "tokens_delivery_requests", cfg, retrier.RetryTopics(topics...))
+	if err != nil {
+		consumer.Close()
+		retrier.Close()
+		return nil, err
 	}
 
-	if err == nil || *err == nil {
-		return
+	c := &tokensDeliveryRequests{
+		consumer:      consumer,
+		retryConsumer: retryConsumer,
+		retrier:       retrier,
+		logger:        logger,
+		service:       service,
+		dedup:         dedup,
+		routes:        routes,
 	}
-
-	var serviceErr = &models.ServiceError{}
-	if !errors.As(*err, &serviceErr) {
-		*err = models.Error(models.Internal, "error while sending event notification")
+	c.pool = newWorkerPool(cfg.Pool, consumer, c.Consume, logger, probes.Loop("tokens_delivery_requests"))
+	if retryConsumer != nil {
+		c.retryPool = newWorkerPool(cfg.Pool, retryConsumer, c.Consume, logger, probes.Loop("tokens_delivery_requests_retry"))
 	}
+	return c, nil
 }
 
-func (e *tokensDeliveryRequests) logError(err error, functionName string) {
-	if err == nil {
-		return
-	}
+func (c *tokensDeliveryRequests) Run(ctx context.Context) {
+	runPools(ctx, c.pool, c.retryPool)
 
-	var eventsErr = &models.ServiceError{}
-	if errors.As(err, &eventsErr) {
-		e.logger.WithFields(
-			logrus.Fields{
-				"error.function.name": functionName,
-				"error.msg":           eventsErr.Msg,
-				"error.code":          eventsErr.Code,
-			},
-		).Error("tokens delivery error occurred")
-	} else {
-		e.logger.WithFields(
-			logrus.Fields{
-				"error.function.name": functionName,
-				"error.msg":           err.Error(),
-			},
-		).Error("tokens delivery error occurred")
-	}
+	c.logger.Info("tokens delivery consumer shutting down")
+	c.Shutdown()
+	c.logger.Info("tokens delivery consumer shutted down")
+}
+
+// Ping checks that the message broker is reachable.
+func (c *tokensDeliveryRequests) Ping(ctx context.Context) error {
+	return c.consumer.Ping(ctx)
+}
+
+func (e *tokensDeliveryRequests) Shutdown() error {
+	return errors.Join(closeConsumers(e.consumer, e.retryConsumer), e.retrier.Close())
 }
 
 type tokenDeviveryRequest struct {
@@ -114,43 +96,82 @@ type tokenDeviveryRequest struct {
 	CallbackUrlTtl time.Duration `json:"callback_url_ttl"`
 }
 
-func (c *tokensDeliveryRequests) Consume(ctx context.Context) {
-	var err error
-	defer c.handleError(ctx, &err)
+func (r tokenDeviveryRequest) validate() error {
+	switch {
+	case r.Email == "":
+		return models.Error(models.InvalidArgument, "email is empty")
+	case r.Token == "":
+		return models.Error(models.InvalidArgument, "token is empty")
+	case r.CallbackUrl == "":
+		return models.Error(models.InvalidArgument, "callback_url is empty")
+	}
+	return nil
+}
 
-	message, err := c.reader.FetchMessage(ctx)
-	if err != nil {
-		return
+// Consume handles the fetched message, the message is acknowledged by the worker pool.
+func (c *tokensDeliveryRequests) Consume(ctx context.Context, message Message, loop *health.Loop) (err error) {
+	defer handleError(ctx, &err)
+
+	topic := originalTopic(message)
+	consumedEvents.WithLabelValues(topic).Inc()
+	if err = c.retrier.Wait(ctx, message); err != nil {
+		return err
+	}
+	defer loop.Busy()()
+
+	ctx, span := startProcessSpan(ctx, message)
+	defer span.End()
+	eventType := eventType(message)
+	ctx = withMessageLogger(ctx, c.logger, message, eventType)
+
+	route, ok := c.routes.Match(topic, eventType)
+	if !ok {
+		err = models.Errorf(models.InvalidArgument, "no route for the event type %s", eventType)
+		tracing.RecordError(span, err)
+		return c.retrier.Quarantine(ctx, message, err)
 	}
 
 	var tokensDeliveryRequest tokenDeviveryRequest
 
+	_, unmarshalSpan := tracer.Start(ctx, "unmarshal")
 	err = json.Unmarshal(message.Value, &tokensDeliveryRequest)
+	if err == nil {
+		err = tokensDeliveryRequest.validate()
+	}
+	tracing.EndSpan(unmarshalSpan, err)
 	if err != nil {
-		// skip messages with invalid structure
-		err = c.reader.CommitMessages(ctx, message)
-		return
+		tracing.RecordError(span, err)
+		return c.retrier.Quarantine(ctx, message, err)
 	}
+	ctx = logging.ContextWithFields(ctx, c.logger, logrus.Fields{
+		"recipient": logging.HashRecipient(tokensDeliveryRequest.Email),
+	})
 
-	Expired := time.Since(message.Time) >= tokensDeliveryRequest.CallbackUrlTtl
+	sended := originalTime(message)
+	Expired := time.Since(sended) >= tokensDeliveryRequest.CallbackUrlTtl
 	if Expired {
-		c.logger.Debugf("Message expired, message sended: %s. %s since message sended. linkTTL: %s",
-			message.Time, time.Since(message.Time), time.Duration(tokensDeliveryRequest.CallbackUrlTtl))
-		err = c.reader.CommitMessages(ctx, message)
-		return
-	}
-
-	topic := service.EmailVerificationTopic
-	if message.Topic == passwordChangeTopic {
-		topic = service.PasswordChangingTopic
+		logging.FromContext(ctx, c.logger).Debugf("Message expired, message sended: %s. %s since message sended. linkTTL: %s",
+			sended, time.Since(sended), time.Duration(tokensDeliveryRequest.CallbackUrlTtl))
+		span.AddEvent("message expired")
+		handledEvents.WithLabelValues(topic, resultExpired).Inc()
+		return nil
 	}
 
-	err = c.service.SendTokenToEmail(ctx, tokensDeliveryRequest.Email, tokensDeliveryRequest.CallbackUrl+"/"+tokensDeliveryRequest.Token,
-		topic, tokensDeliveryRequest.CallbackUrlTtl-time.Since(message.Time))
-
-	if err != nil {
-		return
+	key := idempotency.HashKey(eventType, tokensDeliveryRequest.Token)
+	duplicate, err := deliverOnce(ctx, c.dedup, c.logger, key, func(ctx context.Context) error {
+		return c.service.SendTokenToEmail(ctx, tokensDeliveryRequest.Email, tokensDeliveryRequest.CallbackUrl+"/"+tokensDeliveryRequest.Token,
+			route.MailTemplate(), tokensDeliveryRequest.CallbackUrlTtl-time.Since(sended))
+	})
+	switch {
+	case err != nil:
+		logError(logging.FromContext(ctx, c.logger), "tokens delivery", err, "Consume")
+		tracing.RecordError(span, err)
+		handledEvents.WithLabelValues(topic, resultFailed).Inc()
+		return c.retrier.Retry(ctx, message, err)
+	case duplicate:
+		handledEvents.WithLabelValues(topic, resultDuplicate).Inc()
+	default:
+		handledEvents.WithLabelValues(topic, resultProcessed).Inc()
 	}
-
-	err = c.reader.CommitMessages(ctx, message)
+	return nil
 }
@@ -0,0 +1,46 @@
+package events
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/Falokut/email_service/internal/events")
+
+// startProcessSpan starts the consumer span, which continues the trace of the message producer.
+// Retried messages keep the headers, so they continue the same trace.
+func startProcessSpan(ctx context.Context, message Message) (context.Context, trace.Span) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(message.Headers))
+	topic := originalTopic(message)
+
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystemKey.String(message.system),
+		semconv.MessagingOperationDeliver,
+		semconv.MessagingDestinationName(topic),
+	}
+	if message.system == systemKafka {
+		attrs = append(attrs,
+			semconv.MessagingKafkaDestinationPartition(message.Partition),
+			semconv.MessagingKafkaMessageOffset(int(message.Offset)),
+			semconv.MessagingKafkaMessageKey(string(message.Key)),
+			attribute.Int("messaging.kafka.attempt", messageAttempt(message)),
+			// retry topic for the retried messages
+			attribute.String("messaging.kafka.source_topic", message.Topic),
+		)
+	} else {
+		attrs = append(attrs,
+			attribute.Int("messaging.attempt", messageAttempt(message)),
+			// retry subject or queue for the retried messages
+			attribute.String("messaging.source_destination", message.Topic),
+		)
+	}
+
+	return tracer.Start(ctx, topic+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attrs...))
+}
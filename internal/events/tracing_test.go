@@ -0,0 +1,174 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Falokut/email_service/internal/email"
+	"github.com/Falokut/email_service/internal/service"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// acceptingSMTP starts the smtp server, which accepts every message, and returns its port.
+func acceptingSMTP(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				conn.Write([]byte("220 localhost ESMTP\r\n"))
+				for data := false; ; {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+					case data:
+						if line == ".\r\n" {
+							data = false
+							conn.Write([]byte("250 queued\r\n"))
+						}
+					case cmd == "DATA":
+						data = true
+						conn.Write([]byte("354 end data with <CR><LF>.<CR><LF>\r\n"))
+					case cmd == "QUIT":
+						conn.Write([]byte("221 bye\r\n"))
+						return
+					default:
+						conn.Write([]byte("250 ok\r\n"))
+					}
+				}
+			}(conn)
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// newTracingTestService makes the mail service with the templates of the repository,
+// which sends mail through the smtp sender.
+func newTracingTestService(t *testing.T) service.MailService {
+	t.Helper()
+	sender, err := email.NewMailSender(email.MailSenderConfig{
+		EmailAddress: "noreply@cinema.local",
+		Host:         "127.0.0.1",
+		Port:         acceptingSMTP(t),
+		TLS:          email.TLSConfig{Mode: email.Plaintext},
+	}, logrus.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(sender.Shutdown)
+
+	s, err := service.NewMailService(sender, nil, "../../templates", nil, logrus.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// spanRecorder installs the global tracer provider once, tracers of the packages are bound to the first one.
+var spanRecorder = sync.OnceValue(func() *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return recorder
+})
+
+func TestConsumeSpans(t *testing.T) {
+	recorder := spanRecorder()
+
+	routes, err := NewRoutingTable([]Route{{
+		Topic: "tokens", Handler: TokenHandler, Subject: "Account activation", Template: "accountActivation.html",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewTokensDeliveryRequestsConsumer(ConsumerConfig{}, NewMemoryBroker(), logrus.New(),
+		newTracingTestService(t), nil, nil, routes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	// producer span, which context is propagated in the message headers
+	producerCtx, producer := otel.Tracer("producer").Start(context.Background(), "tokens publish")
+	producer.End()
+	headers := make(map[string]string)
+	otel.GetTextMapPropagator().Inject(producerCtx, propagation.MapCarrier(headers))
+
+	value, err := json.Marshal(tokenDeviveryRequest{
+		Email:          "user@example.com",
+		Token:          "token",
+		CallbackUrl:    "https://cinema.local/activate",
+		CallbackUrlTtl: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := Message{Topic: "tokens", Value: value, Headers: headers, Time: time.Now(), system: systemMemory}
+	if err = c.Consume(context.Background(), message, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// spans of the other runs of the test are in the other traces
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID() == producer.SpanContext().TraceID() {
+			spans[span.Name()] = span
+		}
+	}
+	process, ok := spans["tokens process"]
+	if !ok {
+		t.Fatalf("no consume span in %v", spanNames(recorder.Ended()))
+	}
+	if process.SpanKind() != trace.SpanKindConsumer {
+		t.Errorf("consume span kind %s, want consumer", process.SpanKind())
+	}
+	if parent := process.Parent(); !parent.IsRemote() || parent.SpanID() != producer.SpanContext().SpanID() {
+		t.Errorf("consume span parent %s, want the remote producer span %s", parent.SpanID(), producer.SpanContext().SpanID())
+	}
+
+	for _, name := range []string{"unmarshal", "render", "smtp send"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("no %s span in %v", name, spanNames(recorder.Ended()))
+			continue
+		}
+		if span.Parent().SpanID() != process.SpanContext().SpanID() {
+			t.Errorf("%s span parent %s, want the consume span %s", name, span.Parent().SpanID(), process.SpanContext().SpanID())
+		}
+	}
+	if send, ok := spans["smtp send"]; ok && send.SpanKind() != trace.SpanKindClient {
+		t.Errorf("send span kind %s, want client", send.SpanKind())
+	}
+}
+
+func spanNames(spans []sdktrace.ReadOnlySpan) []string {
+	names := make([]string, 0, len(spans))
+	for _, span := range spans {
+		names = append(names, span.Name())
+	}
+	return names
+}
@@ -0,0 +1,271 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type Config struct {
+	Host string `yaml:"host" env:"HEALTHCHECK_HOST"`
+	Port string `yaml:"port" env:"HEALTHCHECK_PORT"`
+	// consume loop handling single message longer than this is considered stuck
+	StallTimeout time.Duration `yaml:"stall_timeout" env:"HEALTHCHECK_STALL_TIMEOUT"`
+	// timeout of the each readiness check
+	CheckTimeout time.Duration `yaml:"check_timeout" env:"HEALTHCHECK_CHECK_TIMEOUT"`
+}
+
+const (
+	defaultStallTimeout = 5 * time.Minute
+	defaultCheckTimeout = 3 * time.Second
+)
+
+// Check returns nil if the dependency is available.
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Server serves the liveness probe on the /healthz and the readiness probe on the /readyz.
+type Server struct {
+	cfg    Config
+	logger *logrus.Logger
+
+	mu     sync.Mutex
+	checks []namedCheck
+	loops  []*Loop
+}
+
+func NewServer(cfg Config, logger *logrus.Logger) *Server {
+	if cfg.StallTimeout <= 0 {
+		cfg.StallTimeout = defaultStallTimeout
+	}
+	if cfg.CheckTimeout <= 0 {
+		cfg.CheckTimeout = defaultCheckTimeout
+	}
+	return &Server{cfg: cfg, logger: logger}
+}
+
+// AddReadinessCheck adds the dependency check, which is run on each readiness probe.
+func (s *Server) AddReadinessCheck(name string, check Check) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checks = append(s.checks, namedCheck{name: name, check: check})
+}
+
+// Loop registers the consume loop, which progress is checked by the liveness probe.
+// Returns nil on the nil server, nil loop doesn't track anything.
+func (s *Server) Loop(name string) *Loop {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	loop := &Loop{name: name}
+	s.loops = append(s.loops, loop)
+	return loop
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.liveness)
+	mux.HandleFunc("/readyz", s.readiness)
+	return mux
+}
+
+// Run serves probes until ctx is done.
+func (s *Server) Run(ctx context.Context) error {
+	server := &http.Server{
+		Addr:              net.JoinHostPort(s.cfg.Host, s.cfg.Port),
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	s.logger.Infof("healthcheck server listening on %s", server.Addr)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+type response struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+const (
+	statusOk   = "ok"
+	statusFail = "fail"
+)
+
+func (s *Server) liveness(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	loops := append([]*Loop(nil), s.loops...)
+	s.mu.Unlock()
+
+	results := make(map[string]error, len(loops))
+	for _, loop := range loops {
+		results[loop.name] = loop.check(s.cfg.StallTimeout)
+	}
+	s.write(w, results)
+}
+
+func (s *Server) readiness(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	checks := append([]namedCheck(nil), s.checks...)
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.cfg.CheckTimeout)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]error, len(checks))
+	for _, c := range checks {
+		wg.Add(1)
+		go func(c namedCheck) {
+			defer wg.Done()
+			err := c.check(ctx)
+			mu.Lock()
+			results[c.name] = err
+			mu.Unlock()
+		}(c)
+	}
+	wg.Wait()
+	s.write(w, results)
+}
+
+func (s *Server) write(w http.ResponseWriter, results map[string]error) {
+	res := response{Status: statusOk, Checks: make(map[string]string, len(results))}
+	for name, err := range results {
+		if err != nil {
+			s.logger.Warnf("health check %s failed: %v", name, err)
+			res.Status = statusFail
+			res.Checks[name] = err.Error()
+			continue
+		}
+		res.Checks[name] = statusOk
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if res.Status != statusOk {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+// Loop tracks progress of the consume loop.
+// Waiting for the new message isn't a stall, so only the message handling is timed.
+type Loop struct {
+	name string
+
+	mu sync.Mutex
+	// start time of the messages being handled
+	busy   map[uint64]time.Time
+	nextID uint64
+	// failures by the reason, loop is not alive if it's failing longer than the stall timeout
+	failures map[string]failure
+	stopped  bool
+}
+
+type failure struct {
+	since time.Time
+	err   error
+}
+
+// Busy marks the start of the message handling, returned func marks the end.
+// Loop may handle several messages at once.
+func (l *Loop) Busy() (done func()) {
+	if l == nil {
+		return func() {}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.busy == nil {
+		l.busy = make(map[uint64]time.Time)
+	}
+	id := l.nextID
+	l.nextID++
+	l.busy[id] = time.Now()
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		delete(l.busy, id)
+	}
+}
+
+// Fail marks the loop as unable to make progress for the reason, e.g. when messages can't be fetched.
+// Repeated failures with the same reason keep the time of the first one.
+func (l *Loop) Fail(reason string, err error) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.failures == nil {
+		l.failures = make(map[string]failure)
+	}
+	f, ok := l.failures[reason]
+	if !ok {
+		f.since = time.Now()
+	}
+	f.err = err
+	l.failures[reason] = f
+}
+
+// Recover clears the failure with the reason.
+func (l *Loop) Recover(reason string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, reason)
+}
+
+// Stop marks the loop as exited, the loop is not alive after that.
+func (l *Loop) Stop() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stopped = true
+}
+
+func (l *Loop) check(stallTimeout time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.stopped {
+		return errors.New("consume loop stopped")
+	}
+	for _, since := range l.busy {
+		if time.Since(since) > stallTimeout {
+			return fmt.Errorf("consume loop is handling message for %s", time.Since(since).Round(time.Second))
+		}
+	}
+	for reason, f := range l.failures {
+		if time.Since(f.since) > stallTimeout {
+			return fmt.Errorf("consume loop is failing for %s, %s: %v", time.Since(f.since).Round(time.Second), reason, f.err)
+		}
+	}
+	return nil
+}
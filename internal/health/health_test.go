@@ -0,0 +1,169 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func probe(t *testing.T, s *Server, path string) (int, response) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+	var res response
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return rec.Code, res
+}
+
+func TestLiveness(t *testing.T) {
+	const stallTimeout = 20 * time.Millisecond
+	tests := []struct {
+		name       string
+		loop       func(l *Loop)
+		wantStatus int
+	}{
+		{name: "idle", loop: func(l *Loop) {}, wantStatus: http.StatusOK},
+		{name: "handled", loop: func(l *Loop) {
+			done := l.Busy()
+			time.Sleep(2 * stallTimeout)
+			done()
+		}, wantStatus: http.StatusOK},
+		{name: "busy", loop: func(l *Loop) { l.Busy() }, wantStatus: http.StatusOK},
+		{name: "stalled", loop: func(l *Loop) {
+			l.Busy()
+			time.Sleep(2 * stallTimeout)
+		}, wantStatus: http.StatusServiceUnavailable},
+		{name: "stopped", loop: func(l *Loop) { l.Stop() }, wantStatus: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(Config{StallTimeout: stallTimeout}, logrus.New())
+			s.Loop("healthy")
+			loop := s.Loop("checked")
+			tt.loop(loop)
+
+			status, res := probe(t, s, "/healthz")
+			if status != tt.wantStatus {
+				t.Errorf("got status %d, want %d", status, tt.wantStatus)
+			}
+			if res.Checks["healthy"] != statusOk {
+				t.Errorf("healthy loop check: got %q, want %q", res.Checks["healthy"], statusOk)
+			}
+			if got := res.Checks["checked"]; (got == statusOk) != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("loop check: got %q", got)
+			}
+		})
+	}
+}
+
+func TestLivenessFailures(t *testing.T) {
+	const stallTimeout = 20 * time.Millisecond
+	s := NewServer(Config{StallTimeout: stallTimeout}, logrus.New())
+	loop := s.Loop("consumer")
+
+	// loop is alive until it's failing longer than the stall timeout
+	loop.Fail("fetch", errors.New("connection refused"))
+	if status, _ := probe(t, s, "/healthz"); status != http.StatusOK {
+		t.Errorf("got status %d after the first failure, want %d", status, http.StatusOK)
+	}
+	time.Sleep(stallTimeout)
+	// repeated failure keeps the time of the first one
+	loop.Fail("fetch", errors.New("broker not available"))
+	status, res := probe(t, s, "/healthz")
+	if status != http.StatusServiceUnavailable {
+		t.Errorf("got status %d after the stall timeout, want %d", status, http.StatusServiceUnavailable)
+	}
+	if got := res.Checks["consumer"]; !strings.Contains(got, "fetch: broker not available") {
+		t.Errorf("got check %q, want the last error of the failure", got)
+	}
+
+	loop.Recover("fetch")
+	if status, _ := probe(t, s, "/healthz"); status != http.StatusOK {
+		t.Errorf("got status %d after recover, want %d", status, http.StatusOK)
+	}
+	// failure after recover starts the new period
+	loop.Fail("fetch", errors.New("connection refused"))
+	if status, _ := probe(t, s, "/healthz"); status != http.StatusOK {
+		t.Errorf("got status %d after the new failure, want %d", status, http.StatusOK)
+	}
+}
+
+func TestNilLoop(t *testing.T) {
+	var s *Server
+	loop := s.Loop("consumer")
+	if loop != nil {
+		t.Fatal("loop of the nil server isn't nil")
+	}
+	// consumers call the loop methods without the checks
+	loop.Busy()()
+	loop.Fail("fetch", errors.New("connection refused"))
+	loop.Recover("fetch")
+	loop.Stop()
+}
+
+func TestReadiness(t *testing.T) {
+	s := NewServer(Config{CheckTimeout: 50 * time.Millisecond}, logrus.New())
+	s.AddReadinessCheck("ok", func(ctx context.Context) error { return nil })
+	s.AddReadinessCheck("failed", func(ctx context.Context) error { return errors.New("connection refused") })
+	// checks wait for each other, so they pass only when run in parallel
+	first, second := make(chan struct{}), make(chan struct{})
+	waitFor := func(started, other chan struct{}) Check {
+		return func(ctx context.Context) error {
+			close(started)
+			select {
+			case <-other:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	s.AddReadinessCheck("first", waitFor(first, second))
+	s.AddReadinessCheck("second", waitFor(second, first))
+	s.AddReadinessCheck("hanging", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	start := time.Now()
+	status, res := probe(t, s, "/readyz")
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("probe took %s, want the checks cancelled after the check timeout", elapsed)
+	}
+	if status != http.StatusServiceUnavailable || res.Status != statusFail {
+		t.Errorf("got status %d %q, want %d %q", status, res.Status, http.StatusServiceUnavailable, statusFail)
+	}
+
+	want := map[string]string{
+		"ok":      statusOk,
+		"failed":  "connection refused",
+		"first":   statusOk,
+		"second":  statusOk,
+		"hanging": context.DeadlineExceeded.Error(),
+	}
+	for name, check := range want {
+		if got := res.Checks[name]; got != check {
+			t.Errorf("check %s: got %q, want %q", name, got, check)
+		}
+	}
+}
+
+func TestReadinessOk(t *testing.T) {
+	s := NewServer(Config{}, logrus.New())
+	s.AddReadinessCheck("ok", func(ctx context.Context) error { return nil })
+
+	status, res := probe(t, s, "/readyz")
+	if status != http.StatusOK || res.Status != statusOk {
+		t.Errorf("got status %d %q, want %d %q", status, res.Status, http.StatusOK, statusOk)
+	}
+}
@@ -0,0 +1,83 @@
+package httpmail
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"github.com/Falokut/email_service/internal/models"
+)
+
+// mailgunRequest makes multipart request compatible with the mailgun messages api.
+func (s *MailSender) mailgunRequest(ctx context.Context, mail models.Mail) (*http.Request, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+
+	fields := [][2]string{
+		{"from", s.cfg.EmailAddress},
+		{"to", strings.Join(mail.To, ",")},
+		{"subject", mail.Subject},
+	}
+	if len(mail.Cc) > 0 {
+		fields = append(fields, [2]string{"cc", strings.Join(mail.Cc, ",")})
+	}
+	if len(mail.Bcc) > 0 {
+		fields = append(fields, [2]string{"bcc", strings.Join(mail.Bcc, ",")})
+	}
+	if mail.TextBody != "" {
+		fields = append(fields, [2]string{"text", mail.TextBody})
+	}
+	if mail.HTMLBody != "" {
+		fields = append(fields, [2]string{"html", mail.HTMLBody})
+	}
+	if mail.ReplyTo != "" {
+		fields = append(fields, [2]string{"h:Reply-To", mail.ReplyTo})
+	}
+	for k, v := range mail.Headers {
+		fields = append(fields, [2]string{"h:" + k, v})
+	}
+	for _, field := range fields {
+		if err := w.WriteField(field[0], field[1]); err != nil {
+			return nil, err
+		}
+	}
+
+	// mailgun uses file name as the content id of the inline part
+	for _, part := range mail.Inline {
+		if err := writeFile(w, "inline", part.ContentID, part); err != nil {
+			return nil, err
+		}
+	}
+	for _, attachment := range mail.Attachments {
+		if err := writeFile(w, "attachment", attachment.Name, attachment); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.URL, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	req.SetBasicAuth("api", s.cfg.APIKey)
+	return req, nil
+}
+
+func writeFile(w *multipart.Writer, field, filename string, attachment models.Attachment) error {
+	content, err := attachment.ReadContent()
+	if err != nil {
+		return err
+	}
+
+	f, err := w.CreateFormFile(field, filename)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(content)
+	return err
+}
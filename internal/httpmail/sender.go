@@ -0,0 +1,138 @@
+package httpmail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Falokut/email_service/internal/models"
+	"github.com/Falokut/email_service/pkg/logging"
+	"github.com/sirupsen/logrus"
+)
+
+type Provider = string
+
+const (
+	// generic json webhook
+	Webhook  Provider = "WEBHOOK"
+	Mailgun  Provider = "MAILGUN"
+	SendGrid Provider = "SENDGRID"
+)
+
+type Config struct {
+	Provider Provider `yaml:"provider" env:"HTTP_MAIL_PROVIDER"`
+	// full url of the send endpoint, for example https://api.mailgun.net/v3/example.com/messages
+	URL          string `yaml:"url" env:"HTTP_MAIL_URL"`
+	APIKey       string `yaml:"api_key" env:"HTTP_MAIL_API_KEY"`
+	EmailAddress string `yaml:"email_address" env:"HTTP_MAIL_EMAIL_ADDRESS"`
+	// webhook requests are signed with HMAC-SHA256 if not empty
+	SigningSecret string            `yaml:"signing_secret" env:"HTTP_MAIL_SIGNING_SECRET"`
+	Headers       map[string]string `yaml:"headers"`
+	Timeout       time.Duration     `yaml:"timeout" env:"HTTP_MAIL_TIMEOUT"`
+}
+
+const (
+	defaultTimeout = 30 * time.Second
+	// max size of the error response body included in the error
+	maxErrorBodySize = 1024
+)
+
+type requestBuilder func(ctx context.Context, mail models.Mail) (*http.Request, error)
+
+// MailSender sends mail through the http api of the mail provider.
+type MailSender struct {
+	cfg          Config
+	client       *http.Client
+	logger       *logrus.Logger
+	buildRequest requestBuilder
+}
+
+func NewMailSender(cfg Config, logger *logrus.Logger) (*MailSender, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("http mail provider url must be specified")
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+
+	s := &MailSender{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		logger: logger,
+	}
+	switch strings.ToUpper(cfg.Provider) {
+	case Webhook:
+		s.buildRequest = s.webhookRequest
+	case Mailgun:
+		s.buildRequest = s.mailgunRequest
+	case SendGrid:
+		s.buildRequest = s.sendGridRequest
+	default:
+		return nil, fmt.Errorf("unsupported http mail provider %q", cfg.Provider)
+	}
+	return s, nil
+}
+
+func (s *MailSender) SendEmail(ctx context.Context, mail models.Mail) error {
+	logger := logging.FromContext(ctx, s.logger)
+	req, err := s.buildRequest(ctx, mail)
+	if err != nil {
+		logger.Error(err.Error())
+		return models.Errorf(models.Internal, "can't create request: %s", err)
+	}
+	for k, v := range s.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+
+	logger.Infoln("Sending message.")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		logger.Error(err.Error())
+		return requestError(err)
+	}
+	defer resp.Body.Close()
+
+	if err = responseError(resp); err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	logger.Infoln("Message sended.")
+	return nil
+}
+
+func requestError(err error) error {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return models.Error(models.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return models.Error(models.DeadlineExceeded, err.Error())
+	}
+	return models.Errorf(models.Unavailable, "http mail provider: %s", err)
+}
+
+// responseError maps the provider response status to the error.
+// Throttling, server errors and auth failures are transient, other client errors are permanent.
+func responseError(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	msg := fmt.Sprintf("http mail provider responded %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	switch {
+	case resp.StatusCode == http.StatusRequestTimeout,
+		resp.StatusCode == http.StatusTooManyRequests,
+		resp.StatusCode >= 500:
+		return models.Error(models.Unavailable, msg)
+	case resp.StatusCode >= 400:
+		// including 401 and 403, retrying with the same api key won't help
+		return models.Error(models.Rejected, msg)
+	}
+	return models.Error(models.Unknown, msg)
+}
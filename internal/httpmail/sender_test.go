@@ -0,0 +1,246 @@
+package httpmail
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Falokut/email_service/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+type capturedRequest struct {
+	header http.Header
+	body   []byte
+	// parsed multipart form of the mailgun request
+	form *http.Request
+}
+
+// newProvider starts the provider stand-in, which responds with the status and captures the requests.
+func newProvider(t *testing.T, status int) (*httptest.Server, <-chan capturedRequest) {
+	t.Helper()
+	requests := make(chan capturedRequest, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		form := r.Clone(context.Background())
+		form.Body = io.NopCloser(bytes.NewReader(body))
+		requests <- capturedRequest{header: r.Header.Clone(), body: body, form: form}
+
+		w.WriteHeader(status)
+		w.Write([]byte(`{"message":"` + http.StatusText(status) + `"}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func newTestSender(t *testing.T, cfg Config) *MailSender {
+	t.Helper()
+	cfg.EmailAddress = "noreply@cinema.local"
+	s, err := NewMailSender(cfg, logrus.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func testMail() models.Mail {
+	return models.Mail{
+		To:       []string{"user@example.com"},
+		Cc:       []string{"copy@example.com"},
+		ReplyTo:  "support@cinema.local",
+		Subject:  "Order",
+		HTMLBody: `<p>Your order is confirmed</p><img src="cid:qr">`,
+		TextBody: "Your order is confirmed",
+		Headers:  map[string]string{"X-Order-Id": "42"},
+		Inline:   []models.Attachment{{Name: "qr.png", ContentType: "image/png", Content: []byte("png"), ContentID: "qr"}},
+		Attachments: []models.Attachment{
+			{Name: "ticket.pdf", ContentType: "application/pdf", Reader: strings.NewReader("pdf")},
+		},
+	}
+}
+
+func TestWebhookSigning(t *testing.T) {
+	server, requests := newProvider(t, http.StatusAccepted)
+	s := newTestSender(t, Config{Provider: Webhook, URL: server.URL, APIKey: "key", SigningSecret: "secret"})
+
+	if err := s.SendEmail(context.Background(), testMail()); err != nil {
+		t.Fatal(err)
+	}
+	req := <-requests
+
+	timestamp := req.header.Get(signatureTimestampHeader)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(unix, 0)) > time.Minute {
+		t.Fatalf("signature timestamp %q, want the current unix time", timestamp)
+	}
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(req.body)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); req.header.Get(signatureHeader) != want {
+		t.Errorf("signature %q, want %q", req.header.Get(signatureHeader), want)
+	}
+	if req.header.Get("Authorization") != "Bearer key" {
+		t.Errorf("authorization %q, want the bearer api key", req.header.Get("Authorization"))
+	}
+
+	var payload webhookMail
+	if err = json.Unmarshal(req.body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.From != "noreply@cinema.local" || payload.To[0] != "user@example.com" || payload.Headers["X-Order-Id"] != "42" {
+		t.Errorf("unexpected payload %+v", payload)
+	}
+	if len(payload.Attachments) != 2 || !payload.Attachments[0].Inline || string(payload.Attachments[1].Content) != "pdf" {
+		t.Errorf("attachments %+v, want the inline part and the read attachment", payload.Attachments)
+	}
+}
+
+func TestWebhookWithoutSecretIsNotSigned(t *testing.T) {
+	server, requests := newProvider(t, http.StatusOK)
+	s := newTestSender(t, Config{Provider: Webhook, URL: server.URL})
+
+	if err := s.SendEmail(context.Background(), testMail()); err != nil {
+		t.Fatal(err)
+	}
+	req := <-requests
+	if req.header.Get(signatureHeader) != "" || req.header.Get("Authorization") != "" {
+		t.Errorf("request is signed or authorized without the secret and the api key: %v", req.header)
+	}
+}
+
+func TestMailgunRequest(t *testing.T) {
+	server, requests := newProvider(t, http.StatusOK)
+	s := newTestSender(t, Config{Provider: Mailgun, URL: server.URL, APIKey: "key"})
+
+	if err := s.SendEmail(context.Background(), testMail()); err != nil {
+		t.Fatal(err)
+	}
+	req := <-requests
+
+	if user, password, ok := req.form.BasicAuth(); !ok || user != "api" || password != "key" {
+		t.Errorf("basic auth %s:%s, want api:key", user, password)
+	}
+	if err := req.form.ParseMultipartForm(1 << 20); err != nil {
+		t.Fatal(err)
+	}
+	fields := map[string]string{
+		"from":         "noreply@cinema.local",
+		"to":           "user@example.com",
+		"cc":           "copy@example.com",
+		"subject":      "Order",
+		"text":         "Your order is confirmed",
+		"h:Reply-To":   "support@cinema.local",
+		"h:X-Order-Id": "42",
+	}
+	for k, want := range fields {
+		if got := req.form.FormValue(k); got != want {
+			t.Errorf("field %s = %q, want %q", k, got, want)
+		}
+	}
+	if inline := req.form.MultipartForm.File["inline"]; len(inline) != 1 || inline[0].Filename != "qr" {
+		t.Errorf("inline files %v, want one named by the content id", inline)
+	}
+	if attachments := req.form.MultipartForm.File["attachment"]; len(attachments) != 1 || attachments[0].Filename != "ticket.pdf" {
+		t.Errorf("attachment files %v, want ticket.pdf", attachments)
+	}
+}
+
+func TestSendGridRequest(t *testing.T) {
+	server, requests := newProvider(t, http.StatusAccepted)
+	s := newTestSender(t, Config{Provider: SendGrid, URL: server.URL, APIKey: "key"})
+
+	if err := s.SendEmail(context.Background(), testMail()); err != nil {
+		t.Fatal(err)
+	}
+	req := <-requests
+
+	if req.header.Get("Authorization") != "Bearer key" {
+		t.Errorf("authorization %q, want the bearer api key", req.header.Get("Authorization"))
+	}
+	var payload sendGridMail
+	if err := json.Unmarshal(req.body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if len(payload.Personalizations) != 1 || payload.Personalizations[0].To[0].Email != "user@example.com" ||
+		payload.Personalizations[0].Cc[0].Email != "copy@example.com" {
+		t.Errorf("personalizations %+v, want the recipients", payload.Personalizations)
+	}
+	if len(payload.Content) != 2 || payload.Content[0].Type != "text/plain" {
+		t.Errorf("content %+v, want text/plain first", payload.Content)
+	}
+	if payload.ReplyTo == nil || payload.ReplyTo.Email != "support@cinema.local" {
+		t.Errorf("reply to %+v, want support@cinema.local", payload.ReplyTo)
+	}
+	wantAttachments := []sendGridAttachment{
+		{Content: base64.StdEncoding.EncodeToString([]byte("png")), Type: "image/png", Filename: "qr.png", Disposition: "inline", ContentID: "qr"},
+		{Content: base64.StdEncoding.EncodeToString([]byte("pdf")), Type: "application/pdf", Filename: "ticket.pdf", Disposition: "attachment"},
+	}
+	if len(payload.Attachments) != len(wantAttachments) {
+		t.Fatalf("attachments %+v, want %+v", payload.Attachments, wantAttachments)
+	}
+	for i := range wantAttachments {
+		if payload.Attachments[i] != wantAttachments[i] {
+			t.Errorf("attachment %d = %+v, want %+v", i, payload.Attachments[i], wantAttachments[i])
+		}
+	}
+}
+
+func TestResponseStatusMapping(t *testing.T) {
+	tests := []struct {
+		status int
+		code   models.ErrorCode
+	}{
+		{status: http.StatusOK},
+		{status: http.StatusAccepted},
+		{status: http.StatusBadRequest, code: models.Rejected},
+		{status: http.StatusUnprocessableEntity, code: models.Rejected},
+		{status: http.StatusUnauthorized, code: models.Rejected},
+		{status: http.StatusForbidden, code: models.Rejected},
+		{status: http.StatusRequestTimeout, code: models.Unavailable},
+		{status: http.StatusTooManyRequests, code: models.Unavailable},
+		{status: http.StatusInternalServerError, code: models.Unavailable},
+		{status: http.StatusBadGateway, code: models.Unavailable},
+	}
+	for _, provider := range []Provider{Webhook, Mailgun, SendGrid} {
+		for _, tt := range tests {
+			server, requests := newProvider(t, tt.status)
+			s := newTestSender(t, Config{Provider: provider, URL: server.URL, APIKey: "key"})
+
+			err := s.SendEmail(context.Background(), testMail())
+			<-requests
+			if tt.status < http.StatusMultipleChoices {
+				if err != nil {
+					t.Errorf("%s responded %d: error %v, want nil", provider, tt.status, err)
+				}
+				continue
+			}
+			if got := models.Code(err); got != tt.code {
+				t.Errorf("%s responded %d: error code %v, want %v", provider, tt.status, got, tt.code)
+			}
+		}
+	}
+}
+
+func TestUnreachableProviderIsUnavailable(t *testing.T) {
+	server, _ := newProvider(t, http.StatusOK)
+	server.Close()
+	s := newTestSender(t, Config{Provider: Webhook, URL: server.URL})
+
+	if err := s.SendEmail(context.Background(), testMail()); models.Code(err) != models.Unavailable {
+		t.Errorf("error %v, want unavailable", err)
+	}
+}
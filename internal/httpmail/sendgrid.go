@@ -0,0 +1,115 @@
+package httpmail
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+
+	"github.com/Falokut/email_service/internal/models"
+)
+
+type sendGridAddress struct {
+	Email string `json:"email"`
+}
+
+type sendGridPersonalization struct {
+	To  []sendGridAddress `json:"to"`
+	Cc  []sendGridAddress `json:"cc,omitempty"`
+	Bcc []sendGridAddress `json:"bcc,omitempty"`
+}
+
+type sendGridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type sendGridAttachment struct {
+	Content     string `json:"content"`
+	Type        string `json:"type,omitempty"`
+	Filename    string `json:"filename"`
+	Disposition string `json:"disposition"`
+	ContentID   string `json:"content_id,omitempty"`
+}
+
+type sendGridMail struct {
+	Personalizations []sendGridPersonalization `json:"personalizations"`
+	From             sendGridAddress           `json:"from"`
+	ReplyTo          *sendGridAddress          `json:"reply_to,omitempty"`
+	Subject          string                    `json:"subject"`
+	Content          []sendGridContent         `json:"content"`
+	Attachments      []sendGridAttachment      `json:"attachments,omitempty"`
+	Headers          map[string]string         `json:"headers,omitempty"`
+}
+
+func sendGridAddresses(emails []string) []sendGridAddress {
+	addresses := make([]sendGridAddress, 0, len(emails))
+	for _, email := range emails {
+		addresses = append(addresses, sendGridAddress{Email: email})
+	}
+	return addresses
+}
+
+// sendGridRequest makes json request compatible with the sendgrid v3 mail send api.
+func (s *MailSender) sendGridRequest(ctx context.Context, mail models.Mail) (*http.Request, error) {
+	payload := sendGridMail{
+		Personalizations: []sendGridPersonalization{{
+			To:  sendGridAddresses(mail.To),
+			Cc:  sendGridAddresses(mail.Cc),
+			Bcc: sendGridAddresses(mail.Bcc),
+		}},
+		From:    sendGridAddress{Email: s.cfg.EmailAddress},
+		Subject: mail.Subject,
+		Headers: mail.Headers,
+	}
+	if mail.ReplyTo != "" {
+		payload.ReplyTo = &sendGridAddress{Email: mail.ReplyTo}
+	}
+	// text/plain must go first
+	if mail.TextBody != "" {
+		payload.Content = append(payload.Content, sendGridContent{Type: "text/plain", Value: mail.TextBody})
+	}
+	if mail.HTMLBody != "" {
+		payload.Content = append(payload.Content, sendGridContent{Type: "text/html", Value: mail.HTMLBody})
+	}
+
+	for _, part := range mail.Inline {
+		content, err := part.ReadContent()
+		if err != nil {
+			return nil, err
+		}
+		payload.Attachments = append(payload.Attachments, sendGridAttachment{
+			Content:     base64.StdEncoding.EncodeToString(content),
+			Type:        part.ContentType,
+			Filename:    part.Name,
+			Disposition: "inline",
+			ContentID:   part.ContentID,
+		})
+	}
+	for _, attachment := range mail.Attachments {
+		content, err := attachment.ReadContent()
+		if err != nil {
+			return nil, err
+		}
+		payload.Attachments = append(payload.Attachments, sendGridAttachment{
+			Content:     base64.StdEncoding.EncodeToString(content),
+			Type:        attachment.ContentType,
+			Filename:    attachment.Name,
+			Disposition: "attachment",
+		})
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+s.cfg.APIKey)
+	return req, nil
+}
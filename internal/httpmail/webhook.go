@@ -0,0 +1,104 @@
+package httpmail
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Falokut/email_service/internal/models"
+)
+
+const (
+	signatureHeader          = "X-Signature"
+	signatureTimestampHeader = "X-Signature-Timestamp"
+)
+
+type webhookAttachment struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type,omitempty"`
+	// base64 encoded
+	Content   []byte `json:"content"`
+	ContentID string `json:"content_id,omitempty"`
+	Inline    bool   `json:"inline"`
+}
+
+type webhookMail struct {
+	From        string              `json:"from"`
+	To          []string            `json:"to"`
+	Cc          []string            `json:"cc,omitempty"`
+	Bcc         []string            `json:"bcc,omitempty"`
+	ReplyTo     string              `json:"reply_to,omitempty"`
+	Subject     string              `json:"subject"`
+	HTML        string              `json:"html,omitempty"`
+	Text        string              `json:"text,omitempty"`
+	Headers     map[string]string   `json:"headers,omitempty"`
+	Attachments []webhookAttachment `json:"attachments,omitempty"`
+}
+
+func (s *MailSender) webhookRequest(ctx context.Context, mail models.Mail) (*http.Request, error) {
+	payload := webhookMail{
+		From:    s.cfg.EmailAddress,
+		To:      mail.To,
+		Cc:      mail.Cc,
+		Bcc:     mail.Bcc,
+		ReplyTo: mail.ReplyTo,
+		Subject: mail.Subject,
+		HTML:    mail.HTMLBody,
+		Text:    mail.TextBody,
+		Headers: mail.Headers,
+	}
+
+	for _, part := range mail.Inline {
+		content, err := part.ReadContent()
+		if err != nil {
+			return nil, err
+		}
+		payload.Attachments = append(payload.Attachments, webhookAttachment{
+			Name: part.Name, ContentType: part.ContentType, Content: content, ContentID: part.ContentID, Inline: true,
+		})
+	}
+	for _, attachment := range mail.Attachments {
+		content, err := attachment.ReadContent()
+		if err != nil {
+			return nil, err
+		}
+		payload.Attachments = append(payload.Attachments, webhookAttachment{
+			Name: attachment.Name, ContentType: attachment.ContentType, Content: content,
+		})
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+s.cfg.APIKey)
+	}
+	if s.cfg.SigningSecret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(signatureTimestampHeader, timestamp)
+		req.Header.Set(signatureHeader, "sha256="+sign(s.cfg.SigningSecret, timestamp, body))
+	}
+	return req, nil
+}
+
+// sign makes HMAC-SHA256 of the "timestamp.body", timestamp protects from the replay.
+func sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,99 @@
+package ical
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Falokut/email_service/internal/models"
+)
+
+const (
+	ContentType = "text/calendar; charset=utf-8; method=PUBLISH"
+	prodId      = "-//CinemaParadise//email_service//RU"
+	// RFC 5545 recommends folding lines longer than 75 octets
+	maxLineLength = 75
+	dateFormat    = "20060102T150405Z"
+)
+
+type Event struct {
+	UID         string
+	Start       time.Time
+	Duration    time.Duration
+	Summary     string
+	Description string
+	Location    string
+	// nil if unknown
+	Geo *models.Coordinates
+	// alarm is triggered this duration before the start, no alarm if zero
+	Reminder time.Duration
+}
+
+// Marshal encodes the event as RFC 5545 calendar with single VEVENT.
+// Times are written in UTC, so the calendar doesn't need VTIMEZONE components.
+func (e Event) Marshal() []byte {
+	var buff bytes.Buffer
+	line := func(name, value string) {
+		writeFolded(&buff, name+":"+value)
+	}
+
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", prodId)
+	line("CALSCALE", "GREGORIAN")
+	line("METHOD", "PUBLISH")
+	line("BEGIN", "VEVENT")
+	line("UID", e.UID)
+	line("DTSTAMP", time.Now().UTC().Format(dateFormat))
+	line("DTSTART", e.Start.UTC().Format(dateFormat))
+	line("DTEND", e.Start.Add(e.Duration).UTC().Format(dateFormat))
+	line("SUMMARY", escapeText(e.Summary))
+	if e.Description != "" {
+		line("DESCRIPTION", escapeText(e.Description))
+	}
+	if e.Location != "" {
+		line("LOCATION", escapeText(e.Location))
+	}
+	if e.Geo != nil {
+		line("GEO", fmt.Sprintf("%f;%f", e.Geo.Lat, e.Geo.Long))
+	}
+	if e.Reminder > 0 {
+		line("BEGIN", "VALARM")
+		line("ACTION", "DISPLAY")
+		line("DESCRIPTION", escapeText(e.Summary))
+		line("TRIGGER", "-PT"+fmt.Sprint(int(e.Reminder.Minutes()))+"M")
+		line("END", "VALARM")
+	}
+	line("END", "VEVENT")
+	line("END", "VCALENDAR")
+
+	return buff.Bytes()
+}
+
+var textEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	";", `\;`,
+	",", `\,`,
+	"\r\n", `\n`,
+	"\n", `\n`,
+)
+
+func escapeText(s string) string {
+	return textEscaper.Replace(s)
+}
+
+// writeFolded writes the content line, folding it without splitting utf-8 sequences.
+func writeFolded(buff *bytes.Buffer, line string) {
+	length := 0
+	for _, r := range line {
+		size := len(string(r))
+		if length+size > maxLineLength {
+			buff.WriteString("\r\n ")
+			length = 1
+		}
+		buff.WriteRune(r)
+		length += size
+	}
+	buff.WriteString("\r\n")
+}
@@ -0,0 +1,134 @@
+package ical
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Falokut/email_service/internal/models"
+)
+
+// contentLines splits the calendar into the physical lines and checks their length and encoding.
+func contentLines(t *testing.T, calendar []byte) []string {
+	t.Helper()
+	s := string(calendar)
+	if !strings.HasSuffix(s, "\r\n") {
+		t.Fatal("calendar doesn't end with CRLF")
+	}
+	lines := strings.Split(strings.TrimSuffix(s, "\r\n"), "\r\n")
+	for _, line := range lines {
+		if len(line) > maxLineLength {
+			t.Errorf("line is %d octets long, want at most %d: %q", len(line), maxLineLength, line)
+		}
+		if !utf8.ValidString(line) {
+			t.Errorf("utf-8 sequence is split by the folding: %q", line)
+		}
+	}
+	return lines
+}
+
+// unfold joins the folded lines back.
+func unfold(lines []string) []string {
+	var res []string
+	for _, line := range lines {
+		if strings.HasPrefix(line, " ") && len(res) > 0 {
+			res[len(res)-1] += line[1:]
+			continue
+		}
+		res = append(res, line)
+	}
+	return res
+}
+
+func property(lines []string, name string) (string, bool) {
+	for _, line := range lines {
+		if value, ok := strings.CutPrefix(line, name+":"); ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+func TestMarshal(t *testing.T) {
+	msk := time.FixedZone("MSK", 3*60*60)
+	event := Event{
+		UID:         "order-1@email_service",
+		Start:       time.Date(2024, 5, 1, 19, 30, 0, 0, msk),
+		Duration:    2 * time.Hour,
+		Summary:     "Фильм, часть 1; \\ режиссёрская версия",
+		Description: "Зал 1:\nряд 1 место 2",
+		Location:    "Кинотеатр, ул. Ленина, 1",
+		Geo:         &models.Coordinates{Lat: 55.75, Long: 37.61},
+		Reminder:    time.Hour,
+	}
+	lines := unfold(contentLines(t, event.Marshal()))
+
+	want := map[string]string{
+		// times are converted into UTC
+		"DTSTART":     "20240501T163000Z",
+		"DTEND":       "20240501T183000Z",
+		"SUMMARY":     `Фильм\, часть 1\; \\ режиссёрская версия`,
+		"DESCRIPTION": `Зал 1:\nряд 1 место 2`,
+		"LOCATION":    `Кинотеатр\, ул. Ленина\, 1`,
+		"GEO":         "55.750000;37.610000",
+		"UID":         "order-1@email_service",
+	}
+	for name, value := range want {
+		if got, ok := property(lines, name); got != value {
+			t.Errorf("%s = %q (present %t), want %q", name, got, ok, value)
+		}
+	}
+	if lines[0] != "BEGIN:VCALENDAR" || lines[len(lines)-1] != "END:VCALENDAR" {
+		t.Errorf("calendar is framed by %q and %q", lines[0], lines[len(lines)-1])
+	}
+
+	alarm := strings.Join(lines, "\n")
+	start, end := strings.Index(alarm, "BEGIN:VALARM"), strings.Index(alarm, "END:VALARM")
+	if start < 0 || end < start || !strings.Contains(alarm, "END:VALARM\nEND:VEVENT") {
+		t.Fatalf("no alarm inside the event:\n%s", alarm)
+	}
+	for _, line := range []string{"ACTION:DISPLAY", "TRIGGER:-PT60M"} {
+		if !strings.Contains(alarm[start:end], line) {
+			t.Errorf("alarm doesn't contain %s", line)
+		}
+	}
+}
+
+func TestMarshalOptionalProperties(t *testing.T) {
+	event := Event{UID: "order-1@email_service", Start: time.Now(), Duration: time.Hour, Summary: "Фильм"}
+	lines := unfold(contentLines(t, event.Marshal()))
+
+	for _, name := range []string{"DESCRIPTION", "LOCATION", "GEO"} {
+		if value, ok := property(lines, name); ok {
+			t.Errorf("%s:%s is written for the empty value", name, value)
+		}
+	}
+	for _, line := range lines {
+		if line == "BEGIN:VALARM" {
+			t.Error("alarm is written without the reminder")
+		}
+	}
+}
+
+func TestWriteFolded(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{name: "short", value: "Фильм"},
+		{name: "ascii", value: strings.Repeat("a", 200)},
+		// two octets per rune, the line limit falls into the middle of the rune
+		{name: "cyrillic", value: "x" + strings.Repeat("ж", 100)},
+		{name: "four octets runes", value: strings.Repeat("🎬", 40)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := Event{Summary: tt.value}
+			lines := unfold(contentLines(t, event.Marshal()))
+			if got, _ := property(lines, "SUMMARY"); got != tt.value {
+				t.Errorf("unfolded summary %q, want %q", got, tt.value)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+var recordsBucket = []byte("records")
+
+const sweepInterval = 10 * time.Minute
+
+// BoltStore keeps the records in the embedded bolt database, so they survive restarts.
+// Expired records are removed periodically.
+type BoltStore struct {
+	db     *bolt.DB
+	ttl    time.Duration
+	logger *logrus.Logger
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+type boltRecord struct {
+	Record
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func NewBoltStore(path string, ttl time.Duration, logger *logrus.Logger) (*BoltStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(recordsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s := &BoltStore{db: db, ttl: ttl, logger: logger, stop: make(chan struct{})}
+	s.wg.Add(1)
+	go s.sweepLoop()
+	return s, nil
+}
+
+func (s *BoltStore) Get(ctx context.Context, key string) (rec Record, found bool, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(recordsBucket).Get([]byte(key))
+		if value == nil {
+			return nil
+		}
+		var stored boltRecord
+		if err := json.Unmarshal(value, &stored); err != nil {
+			return err
+		}
+		if time.Now().Before(stored.ExpiresAt) {
+			rec, found = stored.Record, true
+		}
+		return nil
+	})
+	return rec, found, err
+}
+
+func (s *BoltStore) Put(ctx context.Context, key string, step Step) error {
+	now := time.Now()
+	value, err := json.Marshal(boltRecord{
+		Record:    Record{Step: step, UpdatedAt: now},
+		ExpiresAt: now.Add(s.ttl),
+	})
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(recordsBucket).Put([]byte(key), value)
+	})
+}
+
+func (s *BoltStore) Close() error {
+	close(s.stop)
+	s.wg.Wait()
+	return s.db.Close()
+}
+
+func (s *BoltStore) sweepLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.sweep(); err != nil {
+			s.logger.Errorf("can't remove expired deduplication records: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// sweep removes expired records.
+func (s *BoltStore) sweep() error {
+	now := time.Now()
+	return s.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(recordsBucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var stored boltRecord
+			if json.Unmarshal(v, &stored) == nil && now.Before(stored.ExpiresAt) {
+				continue
+			}
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,91 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBoltStoreReopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "data", "dedup.db")
+	store, err := NewBoltStore(path, time.Hour, logrus.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := Key("order_created", "1")
+	if err = store.Put(ctx, key, Sent); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// records survive the restart
+	store, err = NewBoltStore(path, time.Hour, logrus.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	rec, found, err := store.Get(ctx, key)
+	if err != nil || !found {
+		t.Fatalf("got found %t, error %v after reopen, want found", found, err)
+	}
+	if rec.Step != Sent {
+		t.Errorf("got step %s, want %s", rec.Step, Sent)
+	}
+}
+
+func TestBoltStoreSweep(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewBoltStore(filepath.Join(t.TempDir(), "dedup.db"), time.Hour, logrus.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if err = store.Put(ctx, "live", Sent); err != nil {
+		t.Fatal(err)
+	}
+	expired, err := json.Marshal(boltRecord{
+		Record:    Record{Step: Sent, UpdatedAt: time.Now().Add(-2 * time.Hour)},
+		ExpiresAt: time.Now().Add(-time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(recordsBucket)
+		if err := b.Put([]byte("expired"), expired); err != nil {
+			return err
+		}
+		// record that can't be decoded is removed too
+		return b.Put([]byte("corrupted"), []byte("not json"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = store.sweep(); err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	err = store.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(recordsBucket).ForEach(func(k, v []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "live" {
+		t.Errorf("got keys %v after sweep, want [live]", keys)
+	}
+}
@@ -0,0 +1,32 @@
+package idempotency
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+)
+
+// MemoryStore keeps the records in the LRU cache, records are lost on restart.
+type MemoryStore struct {
+	cache *expirable.LRU[string, Record]
+}
+
+func NewMemoryStore(size int, ttl time.Duration) *MemoryStore {
+	return &MemoryStore{cache: expirable.NewLRU[string, Record](size, nil, ttl)}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (Record, bool, error) {
+	rec, ok := s.cache.Get(key)
+	return rec, ok, nil
+}
+
+func (s *MemoryStore) Put(ctx context.Context, key string, step Step) error {
+	s.cache.Add(key, Record{Step: step, UpdatedAt: time.Now()})
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	s.cache.Purge()
+	return nil
+}
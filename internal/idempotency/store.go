@@ -0,0 +1,92 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Step is the last completed step of the event handling.
+type Step string
+
+const (
+	// event handling started, but the mail may be not sent
+	Started Step = "started"
+	// mail sent, but the event may be not committed
+	Sent Step = "sent"
+	// event committed
+	Committed Step = "committed"
+)
+
+// Done reports whether the mail for the event is already sent.
+func (s Step) Done() bool {
+	return s == Sent || s == Committed
+}
+
+type Record struct {
+	Step      Step      `json:"step"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Store keeps the records of the handled events, records expire after the configured TTL.
+type Store interface {
+	Get(ctx context.Context, key string) (rec Record, found bool, err error)
+	Put(ctx context.Context, key string, step Step) error
+	Close() error
+}
+
+type Config struct {
+	// MEMORY, BOLT, or empty to disable deduplication
+	Store string `yaml:"store" env:"DEDUP_STORE"`
+	// how long the records are kept
+	TTL time.Duration `yaml:"ttl" env:"DEDUP_TTL"`
+	// max number of records in the memory store
+	Size int `yaml:"size" env:"DEDUP_SIZE"`
+	// path to the bolt database file
+	Path string `yaml:"path" env:"DEDUP_PATH"`
+}
+
+const (
+	defaultTTL  = 24 * time.Hour
+	defaultSize = 100000
+	defaultPath = "data/dedup.db"
+)
+
+// NewStore creates the store selected in the config, nil store is returned if the deduplication is disabled.
+func NewStore(cfg Config, logger *logrus.Logger) (Store, error) {
+	if cfg.TTL <= 0 {
+		cfg.TTL = defaultTTL
+	}
+
+	switch strings.ToUpper(cfg.Store) {
+	case "", "NONE":
+		return nil, nil
+	case "MEMORY":
+		if cfg.Size <= 0 {
+			cfg.Size = defaultSize
+		}
+		return NewMemoryStore(cfg.Size, cfg.TTL), nil
+	case "BOLT":
+		if cfg.Path == "" {
+			cfg.Path = defaultPath
+		}
+		return NewBoltStore(cfg.Path, cfg.TTL, logger)
+	}
+	return nil, fmt.Errorf("unsupported deduplication store %q", cfg.Store)
+}
+
+// Key builds the event identity from the event type and the event id.
+func Key(eventType, id string) string {
+	return eventType + ":" + id
+}
+
+// HashKey builds the event identity from the event type and the hash of the secret id, like token.
+func HashKey(eventType, secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return Key(eventType, hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,136 @@
+package idempotency
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newTestStores(t *testing.T, ttl time.Duration) map[string]Store {
+	t.Helper()
+	bolt, err := NewBoltStore(filepath.Join(t.TempDir(), "dedup.db"), ttl, logrus.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores := map[string]Store{
+		"memory": NewMemoryStore(10, ttl),
+		"bolt":   bolt,
+	}
+	t.Cleanup(func() {
+		for name, store := range stores {
+			if err := store.Close(); err != nil {
+				t.Errorf("close %s store: %v", name, err)
+			}
+		}
+	})
+	return stores
+}
+
+func TestStoreSteps(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range newTestStores(t, time.Hour) {
+		t.Run(name, func(t *testing.T) {
+			key := Key("order_created", "1")
+			if _, found, err := store.Get(ctx, key); err != nil || found {
+				t.Fatalf("got found %t, error %v for the new key, want not found", found, err)
+			}
+
+			steps := []struct {
+				step     Step
+				wantDone bool
+			}{
+				{step: Started, wantDone: false},
+				{step: Sent, wantDone: true},
+				{step: Committed, wantDone: true},
+			}
+			for _, s := range steps {
+				before := time.Now()
+				if err := store.Put(ctx, key, s.step); err != nil {
+					t.Fatal(err)
+				}
+				rec, found, err := store.Get(ctx, key)
+				if err != nil || !found {
+					t.Fatalf("%s: got found %t, error %v, want found", s.step, found, err)
+				}
+				if rec.Step != s.step {
+					t.Errorf("got step %s, want %s", rec.Step, s.step)
+				}
+				if rec.Step.Done() != s.wantDone {
+					t.Errorf("%s: got done %t, want %t", s.step, rec.Step.Done(), s.wantDone)
+				}
+				if rec.UpdatedAt.Before(before.Truncate(time.Second)) {
+					t.Errorf("%s: updated at %s isn't updated", s.step, rec.UpdatedAt)
+				}
+			}
+
+			if _, found, _ := store.Get(ctx, Key("order_created", "2")); found {
+				t.Error("record of the other key is found")
+			}
+		})
+	}
+}
+
+func TestStoreTTL(t *testing.T) {
+	const ttl = 50 * time.Millisecond
+	ctx := context.Background()
+	for name, store := range newTestStores(t, ttl) {
+		t.Run(name, func(t *testing.T) {
+			key := Key("order_created", "1")
+			if err := store.Put(ctx, key, Sent); err != nil {
+				t.Fatal(err)
+			}
+			if _, found, _ := store.Get(ctx, key); !found {
+				t.Fatal("record isn't found before the ttl")
+			}
+
+			time.Sleep(2 * ttl)
+			if _, found, err := store.Get(ctx, key); err != nil || found {
+				t.Errorf("got found %t, error %v after the ttl, want not found", found, err)
+			}
+		})
+	}
+}
+
+func TestNewStore(t *testing.T) {
+	tests := []struct {
+		store   string
+		wantNil bool
+		wantErr bool
+	}{
+		{store: "", wantNil: true},
+		{store: "none", wantNil: true},
+		{store: "memory"},
+		{store: "BOLT"},
+		{store: "redis", wantNil: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.store, func(t *testing.T) {
+			store, err := NewStore(Config{Store: tt.store, Path: filepath.Join(t.TempDir(), "dedup.db")}, logrus.New())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if (store == nil) != tt.wantNil {
+				t.Fatalf("got store %v, want nil %t", store, tt.wantNil)
+			}
+			if store != nil {
+				store.Close()
+			}
+		})
+	}
+}
+
+func TestHashKey(t *testing.T) {
+	key := HashKey("email_verification", "secret-token")
+	if key != HashKey("email_verification", "secret-token") {
+		t.Error("hash key isn't stable")
+	}
+	if key == HashKey("email_verification", "other-token") {
+		t.Error("different tokens have the same key")
+	}
+	if len(key) != len("email_verification:")+64 {
+		t.Errorf("got key %q, want the hex sha256 of the token", key)
+	}
+}
@@ -0,0 +1,242 @@
+package mailcatcher
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+//go:embed ui/*.html
+var uiFS embed.FS
+
+var uiTemplates = template.Must(template.New("").Funcs(template.FuncMap{
+	"join": strings.Join,
+	"time": func(t time.Time) string { return t.Format("02.01.2006 15:04:05") },
+}).ParseFS(uiFS, "ui/*.html"))
+
+type handler struct {
+	store  *Store
+	logger *logrus.Logger
+}
+
+// NewHandler creates the web ui for the captured messages and json api for the integration tests.
+func NewHandler(store *Store, logger *logrus.Logger) http.Handler {
+	h := &handler{store: store, logger: logger}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", h.list)
+	mux.HandleFunc("POST /messages/clear", h.clear)
+	mux.HandleFunc("GET /messages/{id}", h.view)
+	mux.HandleFunc("GET /messages/{id}/html", h.html)
+	mux.HandleFunc("GET /messages/{id}/raw", h.raw)
+	mux.HandleFunc("GET /messages/{id}/parts/{index}", h.part)
+	mux.HandleFunc("GET /messages/{id}/cid/{cid}", h.cid)
+	mux.HandleFunc("GET /api/messages", h.apiList)
+	mux.HandleFunc("DELETE /api/messages", h.clear)
+	return mux
+}
+
+type messageSummary struct {
+	*Message
+	Subject string
+}
+
+func (h *handler) list(w http.ResponseWriter, r *http.Request) {
+	messages := h.store.List()
+	summaries := make([]messageSummary, 0, len(messages))
+	for _, msg := range messages {
+		summary := messageSummary{Message: msg}
+		if parsed, err := parseMessage(msg.Raw); err == nil {
+			summary.Subject = parsed.Subject
+		}
+		summaries = append(summaries, summary)
+	}
+	h.render(w, "list.html", summaries)
+}
+
+func (h *handler) clear(w http.ResponseWriter, r *http.Request) {
+	h.store.Clear()
+	if r.Method == http.MethodDelete {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (h *handler) view(w http.ResponseWriter, r *http.Request) {
+	msg, parsed, ok := h.message(w, r)
+	if !ok {
+		return
+	}
+
+	h.render(w, "message.html", struct {
+		*Message
+		*ParsedMessage
+	}{msg, parsed})
+}
+
+var cidURL = regexp.MustCompile(`(?i)cid:([^"'\s)>]+)`)
+
+// html serves html body with cid: urls replaced by the links to the inline parts.
+func (h *handler) html(w http.ResponseWriter, r *http.Request) {
+	msg, parsed, ok := h.message(w, r)
+	if !ok {
+		return
+	}
+
+	body := cidURL.ReplaceAllStringFunc(parsed.HTML, func(s string) string {
+		return fmt.Sprintf("/messages/%d/cid/%s", msg.ID, url.PathEscape(s[len("cid:"):]))
+	})
+	// captured html is untrusted, so scripts are disabled
+	w.Header().Set("Content-Security-Policy", "sandbox; script-src 'none'")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(body))
+}
+
+func (h *handler) raw(w http.ResponseWriter, r *http.Request) {
+	msg, ok := h.storedMessage(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "message/rfc822")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment",
+		map[string]string{"filename": fmt.Sprintf("%d.eml", msg.ID)}))
+	w.Write(msg.Raw)
+}
+
+func (h *handler) part(w http.ResponseWriter, r *http.Request) {
+	_, parsed, ok := h.message(w, r)
+	if !ok {
+		return
+	}
+
+	index, err := strconv.Atoi(r.PathValue("index"))
+	if err != nil || index < 0 || index >= len(parsed.Parts) {
+		http.NotFound(w, r)
+		return
+	}
+
+	part := parsed.Parts[index]
+	filename := part.Filename
+	if filename == "" {
+		filename = fmt.Sprintf("part-%d", index)
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	writePart(w, part)
+}
+
+func (h *handler) cid(w http.ResponseWriter, r *http.Request) {
+	_, parsed, ok := h.message(w, r)
+	if !ok {
+		return
+	}
+
+	part, ok := parsed.PartByContentID(r.PathValue("cid"))
+	if !ok || !strings.HasPrefix(part.ContentType, "image/") {
+		http.NotFound(w, r)
+		return
+	}
+	writePart(w, part)
+}
+
+func writePart(w http.ResponseWriter, part Part) {
+	w.Header().Set("Content-Type", part.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(part.Body)
+}
+
+type apiAttachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	ContentID   string `json:"content_id,omitempty"`
+	Size        int    `json:"size"`
+	URL         string `json:"url"`
+}
+
+type apiMessage struct {
+	ID          int64               `json:"id"`
+	From        string              `json:"from"`
+	To          []string            `json:"to"`
+	Received    time.Time           `json:"received"`
+	Subject     string              `json:"subject"`
+	Headers     map[string][]string `json:"headers"`
+	Text        string              `json:"text"`
+	HTML        string              `json:"html"`
+	Attachments []apiAttachment     `json:"attachments"`
+}
+
+func (h *handler) apiList(w http.ResponseWriter, r *http.Request) {
+	messages := h.store.List()
+	res := make([]apiMessage, 0, len(messages))
+	for _, msg := range messages {
+		item := apiMessage{ID: msg.ID, From: msg.From, To: msg.To, Received: msg.Received}
+		if parsed, err := parseMessage(msg.Raw); err == nil {
+			item.Subject = parsed.Subject
+			item.Headers = parsed.Header
+			item.Text = parsed.Text
+			item.HTML = parsed.HTML
+			for _, part := range parsed.Attachments() {
+				item.Attachments = append(item.Attachments, apiAttachment{
+					Filename:    part.Filename,
+					ContentType: part.ContentType,
+					ContentID:   part.ContentID,
+					Size:        len(part.Body),
+					URL:         fmt.Sprintf("/messages/%d/parts/%d", msg.ID, part.Index),
+				})
+			}
+		}
+		res = append(res, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		h.logger.Error(err)
+	}
+}
+
+func (h *handler) storedMessage(w http.ResponseWriter, r *http.Request) (*Message, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return nil, false
+	}
+
+	msg, ok := h.store.Get(id)
+	if !ok {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	return msg, true
+}
+
+func (h *handler) message(w http.ResponseWriter, r *http.Request) (*Message, *ParsedMessage, bool) {
+	msg, ok := h.storedMessage(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+
+	parsed, err := parseMessage(msg.Raw)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("can't parse message: %s", err), http.StatusUnprocessableEntity)
+		return nil, nil, false
+	}
+	return msg, parsed, true
+}
+
+func (h *handler) render(w http.ResponseWriter, name string, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := uiTemplates.ExecuteTemplate(w, name, data); err != nil {
+		h.logger.Error(err)
+	}
+}
@@ -0,0 +1,151 @@
+package mailcatcher
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newTestHandler(t *testing.T) (*httptest.Server, *Store) {
+	t.Helper()
+	store := NewStore(0)
+	store.Add("noreply@cinema.local", []string{"user@example.com"}, []byte(testMessage))
+	server := httptest.NewServer(NewHandler(store, logrus.New()))
+	t.Cleanup(server.Close)
+	return server, store
+}
+
+func get(t *testing.T, url string) (*http.Response, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+func TestAPIMessages(t *testing.T) {
+	server, _ := newTestHandler(t)
+
+	resp, body := get(t, server.URL+"/api/messages")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status: got %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("content type: got %q, want application/json", ct)
+	}
+
+	var messages []apiMessage
+	if err := json.Unmarshal([]byte(body), &messages); err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+	msg := messages[0]
+	if msg.ID != 1 || msg.From != "noreply@cinema.local" || msg.Subject != "Заказ" {
+		t.Errorf("got message %d from %q with subject %q", msg.ID, msg.From, msg.Subject)
+	}
+	if got := msg.Headers["To"]; len(got) != 1 || got[0] != "user@example.com" {
+		t.Errorf("to header: got %v, want [user@example.com]", got)
+	}
+	if len(msg.Attachments) != 2 {
+		t.Fatalf("got %d attachments, want 2", len(msg.Attachments))
+	}
+
+	// attachment is downloaded by the url from the api
+	pdf := msg.Attachments[1]
+	if pdf.Filename != "tickets.pdf" || pdf.Size != len("%PDF-1.4") {
+		t.Errorf("attachment: got %+v, want tickets.pdf", pdf)
+	}
+	resp, body = get(t, server.URL+pdf.URL)
+	if resp.StatusCode != http.StatusOK || body != "%PDF-1.4" {
+		t.Errorf("attachment download: got %d %q, want the pdf", resp.StatusCode, body)
+	}
+	if cd := resp.Header.Get("Content-Disposition"); !strings.Contains(cd, `filename=tickets.pdf`) {
+		t.Errorf("content disposition: got %q, want attachment with filename", cd)
+	}
+}
+
+func TestHTMLRewritesContentIDs(t *testing.T) {
+	server, _ := newTestHandler(t)
+
+	resp, body := get(t, server.URL+"/messages/1/html")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status: got %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if !strings.Contains(body, `src="/messages/1/cid/logo@cinema.local"`) ||
+		!strings.Contains(body, `src='/messages/1/cid/unknown'`) {
+		t.Errorf("cid urls aren't rewritten: %s", body)
+	}
+	if strings.Contains(body, "cid:") {
+		t.Errorf("html has cid: urls: %s", body)
+	}
+	if csp := resp.Header.Get("Content-Security-Policy"); !strings.Contains(csp, "sandbox") {
+		t.Errorf("content security policy: got %q, want sandbox", csp)
+	}
+
+	resp, body = get(t, server.URL+"/messages/1/cid/logo@cinema.local")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "image/png" {
+		t.Errorf("inline image: got %d %q, want the png", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if !strings.HasPrefix(body, "\x89PNG") {
+		t.Errorf("inline image body: got %q", body)
+	}
+}
+
+func TestHandlerNotFound(t *testing.T) {
+	server, _ := newTestHandler(t)
+
+	for _, path := range []string{
+		"/messages/2",
+		"/messages/abc/raw",
+		"/messages/1/parts/4",
+		"/messages/1/parts/-1",
+		"/messages/1/cid/unknown",
+	} {
+		if resp, _ := get(t, server.URL+path); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: got status %d, want %d", path, resp.StatusCode, http.StatusNotFound)
+		}
+	}
+}
+
+func TestHandlerPages(t *testing.T) {
+	server, store := newTestHandler(t)
+
+	resp, body := get(t, server.URL+"/")
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "Заказ") {
+		t.Errorf("list: got %d, want the page with the message subject", resp.StatusCode)
+	}
+	resp, body = get(t, server.URL+"/messages/1")
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "tickets.pdf") {
+		t.Errorf("message: got %d, want the page with the attachment", resp.StatusCode)
+	}
+	resp, body = get(t, server.URL+"/messages/1/raw")
+	if resp.StatusCode != http.StatusOK || body != testMessage {
+		t.Errorf("raw: got %d, want the captured message", resp.StatusCode)
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, server.URL+"/api/messages", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent || len(store.List()) != 0 {
+		t.Errorf("clear: got %d, %d messages left, want all messages removed", resp.StatusCode, len(store.List()))
+	}
+}
@@ -0,0 +1,136 @@
+package mailcatcher
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+type Part struct {
+	Index       int
+	ContentType string
+	Filename    string
+	// without angle brackets
+	ContentID string
+	Inline    bool
+	Body      []byte
+}
+
+type ParsedMessage struct {
+	Header  mail.Header
+	Subject string
+	Text    string
+	HTML    string
+	// all leaf parts, including the bodies
+	Parts []Part
+}
+
+// Attachments returns parts which are not the message bodies.
+func (m *ParsedMessage) Attachments() []Part {
+	var res []Part
+	for _, part := range m.Parts {
+		if part.Filename != "" || part.ContentID != "" {
+			res = append(res, part)
+		}
+	}
+	return res
+}
+
+// PartByContentID returns the inline part referenced by cid: url.
+func (m *ParsedMessage) PartByContentID(cid string) (Part, bool) {
+	for _, part := range m.Parts {
+		if part.ContentID == cid {
+			return part, true
+		}
+	}
+	return Part{}, false
+}
+
+var wordDecoder = &mime.WordDecoder{}
+
+func parseMessage(raw []byte) (*ParsedMessage, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	subject, err := wordDecoder.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		subject = msg.Header.Get("Subject")
+	}
+
+	parsed := &ParsedMessage{Header: msg.Header, Subject: subject}
+	err = parsed.walk(textproto.MIMEHeader(msg.Header), msg.Body)
+	return parsed, err
+}
+
+// walk collects leaf parts of the entity.
+func (m *ParsedMessage) walk(header textproto.MIMEHeader, body io.Reader) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", nil
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		r := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := r.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err = m.walk(part.Header, part); err != nil {
+				return err
+			}
+		}
+	}
+
+	content, err := io.ReadAll(decodeBody(header.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return err
+	}
+
+	disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	filename := dispositionParams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+	part := Part{
+		Index:       len(m.Parts),
+		ContentType: mediaType,
+		Filename:    filename,
+		ContentID:   strings.Trim(header.Get("Content-ID"), "<>"),
+		Inline:      disposition == "inline",
+		Body:        content,
+	}
+	m.Parts = append(m.Parts, part)
+
+	if disposition == "attachment" || part.ContentID != "" {
+		return nil
+	}
+	switch {
+	case mediaType == "text/plain" && m.Text == "":
+		m.Text = string(content)
+	case mediaType == "text/html" && m.HTML == "":
+		m.HTML = string(content)
+	}
+	return nil
+}
+
+func decodeBody(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	}
+	return body
+}
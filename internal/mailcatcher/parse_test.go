@@ -0,0 +1,111 @@
+package mailcatcher
+
+import (
+	"strings"
+	"testing"
+)
+
+// testMessage is the order notification with the alternative bodies, inline logo and pdf attachment.
+var testMessage = strings.ReplaceAll(`From: noreply@cinema.local
+To: user@example.com
+Subject: =?UTF-8?B?0JfQsNC60LDQtw==?=
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary=mixed
+
+--mixed
+Content-Type: multipart/related; boundary=related
+
+--related
+Content-Type: multipart/alternative; boundary=alt
+
+--alt
+Content-Type: text/plain; charset=UTF-8
+Content-Transfer-Encoding: quoted-printable
+
+Your order is confirmed =E2=9C=93
+--alt
+Content-Type: text/html; charset=UTF-8
+
+<p>Order</p><img src="cid:logo@cinema.local"><img src='cid:unknown'>
+--alt--
+--related
+Content-Type: image/png
+Content-Transfer-Encoding: base64
+Content-ID: <logo@cinema.local>
+Content-Disposition: inline; filename="logo.png"
+
+iVBORw0KGgo=
+--related--
+--mixed
+Content-Type: application/pdf; name="tickets.pdf"
+Content-Transfer-Encoding: base64
+Content-Disposition: attachment; filename="tickets.pdf"
+
+JVBERi0xLjQ=
+--mixed--
+`, "\n", "\r\n")
+
+func TestParseMessage(t *testing.T) {
+	parsed, err := parseMessage([]byte(testMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.Subject != "Заказ" {
+		t.Errorf("subject: got %q, want %q", parsed.Subject, "Заказ")
+	}
+	if want := "Your order is confirmed ✓"; parsed.Text != want {
+		t.Errorf("text: got %q, want %q", parsed.Text, want)
+	}
+	if !strings.Contains(parsed.HTML, `src="cid:logo@cinema.local"`) {
+		t.Errorf("html: got %q, want the body with cid: url", parsed.HTML)
+	}
+	if len(parsed.Parts) != 4 {
+		t.Fatalf("got %d parts, want 4", len(parsed.Parts))
+	}
+
+	attachments := parsed.Attachments()
+	if len(attachments) != 2 {
+		t.Fatalf("got %d attachments, want 2", len(attachments))
+	}
+	pdf := attachments[1]
+	if pdf.Filename != "tickets.pdf" || pdf.ContentType != "application/pdf" || pdf.Inline {
+		t.Errorf("attachment: got %+v, want tickets.pdf", pdf)
+	}
+	if string(pdf.Body) != "%PDF-1.4" {
+		t.Errorf("attachment body: got %q, want %q", pdf.Body, "%PDF-1.4")
+	}
+}
+
+func TestPartByContentID(t *testing.T) {
+	parsed, err := parseMessage([]byte(testMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	part, ok := parsed.PartByContentID("logo@cinema.local")
+	if !ok {
+		t.Fatal("inline part isn't found")
+	}
+	if !part.Inline || part.ContentType != "image/png" || part.Filename != "logo.png" {
+		t.Errorf("inline part: got %+v, want inline logo.png", part)
+	}
+	if !strings.HasPrefix(string(part.Body), "\x89PNG") {
+		t.Errorf("inline part body isn't decoded: %q", part.Body)
+	}
+
+	if _, ok = parsed.PartByContentID("unknown"); ok {
+		t.Error("part is found for the unknown content id")
+	}
+}
+
+func TestParseMessageWithoutContentType(t *testing.T) {
+	parsed, err := parseMessage([]byte("Subject: plain\r\n\r\nbody"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Subject != "plain" || parsed.Text != "body" || len(parsed.Attachments()) != 0 {
+		t.Errorf("got subject %q, text %q, %d attachments, want the plain text message",
+			parsed.Subject, parsed.Text, len(parsed.Attachments()))
+	}
+}
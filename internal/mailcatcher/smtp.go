@@ -0,0 +1,184 @@
+package mailcatcher
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	commandTimeout = 5 * time.Minute
+	// max size of the captured message
+	maxMessageSize = 32 << 20
+)
+
+// SMTPServer accepts all messages and saves them into the store.
+// It doesn't advertise AUTH and STARTTLS, so clients send mail without authentication.
+type SMTPServer struct {
+	store    *Store
+	logger   *logrus.Logger
+	hostname string
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+}
+
+func NewSMTPServer(store *Store, logger *logrus.Logger) *SMTPServer {
+	return &SMTPServer{store: store, logger: logger, hostname: "mailcatcher", conns: map[net.Conn]struct{}{}}
+}
+
+func (s *SMTPServer) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+func (s *SMTPServer) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	s.listener = ln
+	s.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		go func() {
+			defer func() {
+				s.mu.Lock()
+				delete(s.conns, conn)
+				s.mu.Unlock()
+				conn.Close()
+			}()
+			if err := s.handle(conn); err != nil && !errors.Is(err, io.EOF) {
+				s.logger.Warnf("smtp session with %s failed: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// Close stops accepting connections and closes active sessions.
+func (s *SMTPServer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return err
+}
+
+type smtpSession struct {
+	from string
+	to   []string
+}
+
+func (s *SMTPServer) handle(conn net.Conn) error {
+	c := textproto.NewConn(conn)
+	if err := c.PrintfLine("220 %s ESMTP mailcatcher", s.hostname); err != nil {
+		return err
+	}
+
+	var session smtpSession
+	for {
+		conn.SetDeadline(time.Now().Add(commandTimeout))
+		line, err := c.ReadLine()
+		if err != nil {
+			return err
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			err = c.PrintfLine("250 %s", s.hostname)
+		case "EHLO":
+			err = c.PrintfLine("250-%s", s.hostname)
+			if err == nil {
+				err = c.PrintfLine("250-8BITMIME")
+			}
+			if err == nil {
+				err = c.PrintfLine("250 SIZE %d", maxMessageSize)
+			}
+		case "MAIL":
+			session = smtpSession{from: address(arg, "FROM:")}
+			err = c.PrintfLine("250 OK")
+		case "RCPT":
+			session.to = append(session.to, address(arg, "TO:"))
+			err = c.PrintfLine("250 OK")
+		case "DATA":
+			if len(session.to) == 0 {
+				err = c.PrintfLine("503 need RCPT command")
+				break
+			}
+			if err = c.PrintfLine("354 end data with <CR><LF>.<CR><LF>"); err != nil {
+				return err
+			}
+			err = s.receive(c, session)
+			session = smtpSession{}
+		case "RSET":
+			session = smtpSession{}
+			err = c.PrintfLine("250 OK")
+		case "NOOP":
+			err = c.PrintfLine("250 OK")
+		case "QUIT":
+			c.PrintfLine("221 bye")
+			return nil
+		default:
+			err = c.PrintfLine("502 command not implemented")
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *SMTPServer) receive(c *textproto.Conn, session smtpSession) error {
+	r := c.DotReader()
+	raw, err := io.ReadAll(io.LimitReader(r, maxMessageSize+1))
+	if err != nil {
+		return err
+	}
+	if len(raw) > maxMessageSize {
+		// skip the rest of the message
+		if _, err = io.Copy(io.Discard, r); err != nil {
+			return err
+		}
+		return c.PrintfLine("552 message size exceeds %d bytes", maxMessageSize)
+	}
+
+	msg := s.store.Add(session.from, session.to, raw)
+	s.logger.Infof("captured message %d from %s to %s", msg.ID, msg.From, strings.Join(msg.To, ", "))
+	return c.PrintfLine("250 OK: queued as %d", msg.ID)
+}
+
+// address extracts mailbox from the MAIL FROM:<addr> and RCPT TO:<addr> arguments.
+func address(arg, prefix string) string {
+	if len(arg) >= len(prefix) && strings.EqualFold(arg[:len(prefix)], prefix) {
+		arg = arg[len(prefix):]
+	}
+	arg = strings.TrimSpace(arg)
+	if addr, _, ok := strings.Cut(arg, ">"); ok {
+		arg = strings.TrimPrefix(addr, "<")
+	}
+	return arg
+}
@@ -0,0 +1,155 @@
+package mailcatcher
+
+import (
+	"net"
+	"net/smtp"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newTestSMTPServer(t *testing.T, store *Store) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewSMTPServer(store, logrus.New())
+	done := make(chan error, 1)
+	go func() { done <- server.Serve(ln) }()
+	t.Cleanup(func() {
+		server.Close()
+		if err := <-done; err != nil {
+			t.Errorf("serve: %v", err)
+		}
+	})
+	return ln.Addr().String()
+}
+
+func TestSMTPServerCapturesMessage(t *testing.T) {
+	store := NewStore(0)
+	addr := newTestSMTPServer(t, store)
+
+	to := []string{"user@example.com", "copy@example.com"}
+	err := smtp.SendMail(addr, nil, "noreply@cinema.local", to, []byte(testMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := store.List()
+	if len(messages) != 1 {
+		t.Fatalf("got %d captured messages, want 1", len(messages))
+	}
+	msg := messages[0]
+	if msg.From != "noreply@cinema.local" {
+		t.Errorf("from: got %q, want %q", msg.From, "noreply@cinema.local")
+	}
+	if !reflect.DeepEqual(msg.To, to) {
+		t.Errorf("to: got %v, want %v", msg.To, to)
+	}
+	// dot reader of the data normalizes line breaks to \n
+	if want := strings.ReplaceAll(testMessage, "\r\n", "\n"); string(msg.Raw) != want {
+		t.Errorf("raw message:\n%q\nwant:\n%q", msg.Raw, want)
+	}
+	if _, err = parseMessage(msg.Raw); err != nil {
+		t.Errorf("captured message isn't parsed: %v", err)
+	}
+}
+
+func TestSMTPServerSession(t *testing.T) {
+	store := NewStore(0)
+	addr := newTestSMTPServer(t, store)
+
+	client, err := smtp.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err = client.Hello("localhost"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := client.Extension("AUTH"); ok {
+		t.Error("AUTH is advertised")
+	}
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		t.Error("STARTTLS is advertised")
+	}
+
+	// DATA without recipients is rejected
+	if err = client.Mail("noreply@cinema.local"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.Data(); err == nil {
+		t.Error("DATA without recipients is accepted")
+	}
+
+	// session is reset, so only the second transaction is captured
+	if err = client.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if err = client.Mail("other@cinema.local"); err != nil {
+		t.Fatal(err)
+	}
+	if err = client.Rcpt("user@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	w, err := client.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write([]byte("Subject: test\r\n\r\n.leading dot\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = client.Quit(); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := store.List()
+	if len(messages) != 1 {
+		t.Fatalf("got %d captured messages, want 1", len(messages))
+	}
+	if messages[0].From != "other@cinema.local" {
+		t.Errorf("from: got %q, want %q", messages[0].From, "other@cinema.local")
+	}
+	if want := "Subject: test\n\n.leading dot\n"; string(messages[0].Raw) != want {
+		t.Errorf("raw message: got %q, want %q", messages[0].Raw, want)
+	}
+}
+
+func TestAddress(t *testing.T) {
+	tests := []struct {
+		arg, prefix, want string
+	}{
+		{arg: "FROM:<noreply@cinema.local>", prefix: "FROM:", want: "noreply@cinema.local"},
+		{arg: "from: <noreply@cinema.local> BODY=8BITMIME", prefix: "FROM:", want: "noreply@cinema.local"},
+		{arg: "TO:<user@example.com>", prefix: "TO:", want: "user@example.com"},
+		{arg: "TO:user@example.com", prefix: "TO:", want: "user@example.com"},
+		{arg: "FROM:<>", prefix: "FROM:", want: ""},
+	}
+	for _, tt := range tests {
+		if got := address(tt.arg, tt.prefix); got != tt.want {
+			t.Errorf("address(%q): got %q, want %q", tt.arg, got, tt.want)
+		}
+	}
+}
+
+func TestStoreLimit(t *testing.T) {
+	store := NewStore(2)
+	for _, from := range []string{"first", "second", "third"} {
+		store.Add(from, nil, nil)
+	}
+
+	messages := store.List()
+	if len(messages) != 2 || messages[0].From != "third" || messages[1].From != "second" {
+		t.Fatalf("got %d messages, want the last two newest first", len(messages))
+	}
+	if _, ok := store.Get(1); ok {
+		t.Error("the oldest message isn't dropped")
+	}
+}
@@ -0,0 +1,75 @@
+package mailcatcher
+
+import (
+	"sync"
+	"time"
+)
+
+type Message struct {
+	ID       int64
+	From     string
+	To       []string
+	Received time.Time
+	Raw      []byte
+}
+
+// Store keeps the last captured messages in memory.
+type Store struct {
+	mu       sync.RWMutex
+	messages []*Message
+	nextID   int64
+	limit    int
+}
+
+const defaultStoreLimit = 500
+
+func NewStore(limit int) *Store {
+	if limit <= 0 {
+		limit = defaultStoreLimit
+	}
+	return &Store{limit: limit}
+}
+
+// Add saves the message, the oldest message is dropped when the limit exceeded.
+func (s *Store) Add(from string, to []string, raw []byte) *Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	msg := &Message{ID: s.nextID, From: from, To: to, Received: time.Now(), Raw: raw}
+	s.messages = append(s.messages, msg)
+	if len(s.messages) > s.limit {
+		s.messages = s.messages[len(s.messages)-s.limit:]
+	}
+	return msg
+}
+
+// List returns messages, newest first.
+func (s *Store) List() []*Message {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res := make([]*Message, 0, len(s.messages))
+	for i := len(s.messages) - 1; i >= 0; i-- {
+		res = append(res, s.messages[i])
+	}
+	return res
+}
+
+func (s *Store) Get(id int64) (*Message, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, msg := range s.messages {
+		if msg.ID == id {
+			return msg, true
+		}
+	}
+	return nil, false
+}
+
+func (s *Store) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = nil
+}
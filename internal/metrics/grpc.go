@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+var grpcClientHandlingSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: Namespace,
+	Subsystem: "grpc_client",
+	Name:      "handling_seconds",
+	Help:      "Latency of the grpc calls to the other services.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"grpc_service", "grpc_method", "grpc_code"})
+
+// since is replaced in tests to get the predictable latencies
+var since = time.Since
+
+// UnaryClientInterceptor measures latencies of the unary grpc calls.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any,
+		cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+
+		service, name := splitMethodName(method)
+		grpcClientHandlingSeconds.WithLabelValues(service, name, status.Code(err).String()).
+			Observe(since(start).Seconds())
+		return err
+	}
+}
+
+// splitMethodName splits the "/package.service/method" into service and method.
+func splitMethodName(fullMethod string) (string, string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	if i := strings.Index(fullMethod, "/"); i >= 0 {
+		return fullMethod[:i], fullMethod[i+1:]
+	}
+	return "unknown", fullMethod
+}
@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	grpcClientHandlingSeconds.Reset()
+	since = func(time.Time) time.Duration { return 25 * time.Millisecond }
+	t.Cleanup(func() { since = time.Since })
+	interceptor := UnaryClientInterceptor()
+
+	calls := []struct {
+		method string
+		err    error
+	}{
+		{method: "/cinema_service.cinemaServiceV1/GetScreening", err: status.Error(codes.NotFound, "screening not found")},
+		// method without the service
+		{method: "GetMovie"},
+	}
+	for _, call := range calls {
+		invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			if method != call.method {
+				t.Errorf("invoked %q, want %q", method, call.method)
+			}
+			return call.err
+		}
+		if err := interceptor(context.Background(), call.method, nil, nil, nil, invoker); err != call.err {
+			t.Errorf("%s: got error %v, want %v", call.method, err, call.err)
+		}
+	}
+
+	want := `
+# HELP email_service_grpc_client_handling_seconds Latency of the grpc calls to the other services.
+# TYPE email_service_grpc_client_handling_seconds histogram
+email_service_grpc_client_handling_seconds_bucket{grpc_code="NotFound",grpc_method="GetScreening",grpc_service="cinema_service.cinemaServiceV1",le="0.005"} 0
+email_service_grpc_client_handling_seconds_bucket{grpc_code="NotFound",grpc_method="GetScreening",grpc_service="cinema_service.cinemaServiceV1",le="0.01"} 0
+email_service_grpc_client_handling_seconds_bucket{grpc_code="NotFound",grpc_method="GetScreening",grpc_service="cinema_service.cinemaServiceV1",le="0.025"} 1
+email_service_grpc_client_handling_seconds_bucket{grpc_code="NotFound",grpc_method="GetScreening",grpc_service="cinema_service.cinemaServiceV1",le="0.05"} 1
+email_service_grpc_client_handling_seconds_bucket{grpc_code="NotFound",grpc_method="GetScreening",grpc_service="cinema_service.cinemaServiceV1",le="0.1"} 1
+email_service_grpc_client_handling_seconds_bucket{grpc_code="NotFound",grpc_method="GetScreening",grpc_service="cinema_service.cinemaServiceV1",le="0.25"} 1
+email_service_grpc_client_handling_seconds_bucket{grpc_code="NotFound",grpc_method="GetScreening",grpc_service="cinema_service.cinemaServiceV1",le="0.5"} 1
+email_service_grpc_client_handling_seconds_bucket{grpc_code="NotFound",grpc_method="GetScreening",grpc_service="cinema_service.cinemaServiceV1",le="1"} 1
+email_service_grpc_client_handling_seconds_bucket{grpc_code="NotFound",grpc_method="GetScreening",grpc_service="cinema_service.cinemaServiceV1",le="2.5"} 1
+email_service_grpc_client_handling_seconds_bucket{grpc_code="NotFound",grpc_method="GetScreening",grpc_service="cinema_service.cinemaServiceV1",le="5"} 1
+email_service_grpc_client_handling_seconds_bucket{grpc_code="NotFound",grpc_method="GetScreening",grpc_service="cinema_service.cinemaServiceV1",le="10"} 1
+email_service_grpc_client_handling_seconds_bucket{grpc_code="NotFound",grpc_method="GetScreening",grpc_service="cinema_service.cinemaServiceV1",le="+Inf"} 1
+email_service_grpc_client_handling_seconds_sum{grpc_code="NotFound",grpc_method="GetScreening",grpc_service="cinema_service.cinemaServiceV1"} 0.025
+email_service_grpc_client_handling_seconds_count{grpc_code="NotFound",grpc_method="GetScreening",grpc_service="cinema_service.cinemaServiceV1"} 1
+email_service_grpc_client_handling_seconds_bucket{grpc_code="OK",grpc_method="GetMovie",grpc_service="unknown",le="0.005"} 0
+email_service_grpc_client_handling_seconds_bucket{grpc_code="OK",grpc_method="GetMovie",grpc_service="unknown",le="0.01"} 0
+email_service_grpc_client_handling_seconds_bucket{grpc_code="OK",grpc_method="GetMovie",grpc_service="unknown",le="0.025"} 1
+email_service_grpc_client_handling_seconds_bucket{grpc_code="OK",grpc_method="GetMovie",grpc_service="unknown",le="0.05"} 1
+email_service_grpc_client_handling_seconds_bucket{grpc_code="OK",grpc_method="GetMovie",grpc_service="unknown",le="0.1"} 1
+email_service_grpc_client_handling_seconds_bucket{grpc_code="OK",grpc_method="GetMovie",grpc_service="unknown",le="0.25"} 1
+email_service_grpc_client_handling_seconds_bucket{grpc_code="OK",grpc_method="GetMovie",grpc_service="unknown",le="0.5"} 1
+email_service_grpc_client_handling_seconds_bucket{grpc_code="OK",grpc_method="GetMovie",grpc_service="unknown",le="1"} 1
+email_service_grpc_client_handling_seconds_bucket{grpc_code="OK",grpc_method="GetMovie",grpc_service="unknown",le="2.5"} 1
+email_service_grpc_client_handling_seconds_bucket{grpc_code="OK",grpc_method="GetMovie",grpc_service="unknown",le="5"} 1
+email_service_grpc_client_handling_seconds_bucket{grpc_code="OK",grpc_method="GetMovie",grpc_service="unknown",le="10"} 1
+email_service_grpc_client_handling_seconds_bucket{grpc_code="OK",grpc_method="GetMovie",grpc_service="unknown",le="+Inf"} 1
+email_service_grpc_client_handling_seconds_sum{grpc_code="OK",grpc_method="GetMovie",grpc_service="unknown"} 0.025
+email_service_grpc_client_handling_seconds_count{grpc_code="OK",grpc_method="GetMovie",grpc_service="unknown"} 1
+`
+	if err := testutil.CollectAndCompare(grpcClientHandlingSeconds, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSplitMethodName(t *testing.T) {
+	tests := []struct {
+		fullMethod, service, method string
+	}{
+		{fullMethod: "/cinema_service.cinemaServiceV1/GetScreening", service: "cinema_service.cinemaServiceV1", method: "GetScreening"},
+		{fullMethod: "cinema_service.cinemaServiceV1/GetScreening", service: "cinema_service.cinemaServiceV1", method: "GetScreening"},
+		{fullMethod: "GetScreening", service: "unknown", method: "GetScreening"},
+	}
+	for _, tt := range tests {
+		service, method := splitMethodName(tt.fullMethod)
+		if service != tt.service || method != tt.method {
+			t.Errorf("splitMethodName(%q): got %q, %q, want %q, %q", tt.fullMethod, service, method, tt.service, tt.method)
+		}
+	}
+}
@@ -17,6 +17,10 @@ const (
 	Canceled
 	DeadlineExceeded
 	PermissionDenied
+	// Temporary failure, operation may succeed if retried later
+	Unavailable
+	// Permanent failure, retrying won't help
+	Rejected
 )
 
 type ServiceError struct {
@@ -40,6 +44,10 @@ func (t ErrorCode) String() string {
 		return "Canceled"
 	case DeadlineExceeded:
 		return "DeadlineExceeded"
+	case Unavailable:
+		return "Unavailable"
+	case Rejected:
+		return "Rejected"
 	default:
 		return "Unknown"
 	}
@@ -56,6 +64,20 @@ func Code(err error) ErrorCode {
 	}
 	return Unknown
 }
+
+// IsPermanent reports whether the operation that returned err shouldn't be retried.
+func IsPermanent(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	switch Code(err) {
+	case Rejected, InvalidArgument, NotFound:
+		return true
+	}
+	return false
+}
+
 func Error(code ErrorCode, msg string) *ServiceError {
 	return &ServiceError{Code: code, Msg: msg}
 }
@@ -0,0 +1,46 @@
+package models
+
+import "io"
+
+type Mail struct {
+	To      []string
+	Cc      []string
+	Bcc     []string
+	ReplyTo string
+	Subject string
+
+	HTMLBody string
+	TextBody string
+
+	// additional message headers
+	Headers     map[string]string
+	Attachments []Attachment
+	// inline parts, which are referenced from the html body as cid:ContentID
+	Inline []Attachment
+}
+
+type Attachment struct {
+	Name        string
+	ContentType string
+	Content     []byte
+	// Used when Content is empty, read only once
+	Reader io.Reader
+	// Set for inline parts
+	ContentID string
+}
+
+// Recipients returns all envelope recipients of the mail.
+func (m Mail) Recipients() []string {
+	recipients := make([]string, 0, len(m.To)+len(m.Cc)+len(m.Bcc))
+	recipients = append(recipients, m.To...)
+	recipients = append(recipients, m.Cc...)
+	return append(recipients, m.Bcc...)
+}
+
+// ReadContent returns the content or reads it from the Reader, so the attachment can be written several times.
+func (a Attachment) ReadContent() ([]byte, error) {
+	if len(a.Content) > 0 || a.Reader == nil {
+		return a.Content, nil
+	}
+	return io.ReadAll(a.Reader)
+}
@@ -1,9 +1,10 @@
 package models
 
 type TicketNotification struct {
-	Id        string
-	IdBarCode string
-	Row       int32
-	Seat      int32
-	Price     string
+	Id string
+	// content id of the inline barcode image
+	BarCodeCID string
+	Row        int32
+	Seat       int32
+	Price      string
 }
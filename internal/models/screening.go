@@ -1,6 +1,12 @@
 package models
 
+import "time"
+
 type Screening struct {
+	// start time in the cinema timezone
+	Start    time.Time
+	Duration time.Duration
+
 	// formated like hh:mm
 	StartTime string
 	// formated like  dd.mm
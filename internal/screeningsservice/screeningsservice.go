@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -11,18 +12,23 @@ import (
 
 	cinema_service "github.com/Falokut/cinema_service/pkg/cinema_service/v1/protos"
 	"github.com/Falokut/email_service/internal/config"
+	"github.com/Falokut/email_service/internal/metrics"
 	"github.com/Falokut/email_service/internal/models"
+	"github.com/Falokut/email_service/pkg/logging"
 	movies_service "github.com/Falokut/movies_service/pkg/movies_service/v1/protos"
-	"github.com/grpc-ecosystem/grpc-opentracing/go/otgrpc"
-	"github.com/opentracing/opentracing-go"
 	"github.com/ringsaturn/tzf"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/fieldmaskpb"
 )
 
+// longest duration of the movie, which is taken as valid
+const maxMovieDuration = 10 * time.Hour
+
 type ScreeningsService struct {
 	cinemaServiceConn   *grpc.ClientConn
 	cinemaServiceClient cinema_service.CinemaServiceV1Client
@@ -39,10 +45,8 @@ func getGrpcConnection(addr string, cfg config.ConnectionSecureConfig) (*grpc.Cl
 	}
 
 	return grpc.Dial(addr, creds,
-		grpc.WithUnaryInterceptor(
-			otgrpc.OpenTracingClientInterceptor(opentracing.GlobalTracer())),
-		grpc.WithStreamInterceptor(
-			otgrpc.OpenTracingStreamClientInterceptor(opentracing.GlobalTracer())),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+		grpc.WithUnaryInterceptor(metrics.UnaryClientInterceptor()),
 	)
 }
 
@@ -92,6 +96,36 @@ func (s *ScreeningsService) Shutdown() {
 	}
 }
 
+// Ping checks that connections to the cinema and movies services are ready.
+// Idle connections are connected first.
+func (s *ScreeningsService) Ping(ctx context.Context) error {
+	if err := connReady(ctx, s.cinemaServiceConn); err != nil {
+		return fmt.Errorf("cinema service: %w", err)
+	}
+	if err := connReady(ctx, s.moviesServiceConn); err != nil {
+		return fmt.Errorf("movies service: %w", err)
+	}
+	return nil
+}
+
+func connReady(ctx context.Context, conn *grpc.ClientConn) error {
+	for {
+		state := conn.GetState()
+		switch state {
+		case connectivity.Ready:
+			return nil
+		case connectivity.Idle:
+			conn.Connect()
+		case connectivity.Shutdown:
+			return errors.New("connection is closed")
+		}
+
+		if !conn.WaitForStateChange(ctx, state) {
+			return fmt.Errorf("connection is %s", strings.ToLower(state.String()))
+		}
+	}
+}
+
 func (s *ScreeningsService) GetScreeningInfo(ctx context.Context, screeningId int64) (screening models.Screening, err error) {
 	defer s.handleError(ctx, &err, "GetScreeningInfo")
 
@@ -136,7 +170,7 @@ func (s *ScreeningsService) GetScreeningInfo(ctx context.Context, screeningId in
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		name, posterUrl, err := s.getMovieInfo(ctx, res.MovieId)
+		name, posterUrl, duration, err := s.getMovieInfo(ctx, res.MovieId)
 		if err != nil {
 			errCh <- err
 			return
@@ -144,6 +178,7 @@ func (s *ScreeningsService) GetScreeningInfo(ctx context.Context, screeningId in
 
 		screening.MovieName = name
 		screening.MoviePosterUrl = posterUrl
+		screening.Duration = duration
 	}()
 
 	go func() {
@@ -177,6 +212,7 @@ LOOP:
 	startTime, _ := time.Parse(time.RFC3339, res.StartTime.FormattedTimestamp)
 	startTime = startTime.In(tz)
 
+	screening.Start = startTime
 	screening.StartTime = startTime.Format("15:04")
 	screening.StartDate = startTime.Format("02.01")
 
@@ -220,10 +256,11 @@ func (s *ScreeningsService) getHallName(ctx context.Context, hallId int32) (name
 	return res.Halls[0].Name, nil
 }
 
-func (s *ScreeningsService) getMovieInfo(ctx context.Context, movieId int32) (name string, posterUrl string, err error) {
+func (s *ScreeningsService) getMovieInfo(ctx context.Context,
+	movieId int32) (name string, posterUrl string, duration time.Duration, err error) {
 	defer s.handleError(ctx, &err, "getMovieInfo")
 	mask := &fieldmaskpb.FieldMask{}
-	mask.Paths = []string{"title_ru", "poster_url"}
+	mask.Paths = []string{"title_ru", "poster_url", "duration"}
 
 	res, err := s.moviesServiceClient.GetMovie(ctx, &movies_service.GetMovieRequest{
 		MovieID: movieId,
@@ -233,17 +270,23 @@ func (s *ScreeningsService) getMovieInfo(ctx context.Context, movieId int32) (na
 		return
 	}
 
-	return res.TitleRu, res.PosterUrl, nil
+	// duration is in minutes, values out of the feature film range are treated as unknown,
+	// so the invite gets the default duration instead of the one stretched for days
+	duration = time.Duration(res.Duration) * time.Minute
+	if duration <= 0 || duration > maxMovieDuration {
+		duration = 0
+	}
+	return res.TitleRu, res.PosterUrl, duration, nil
 }
 
-func (s *ScreeningsService) logError(err error, functionName string) {
+func (s *ScreeningsService) logError(ctx context.Context, err error, functionName string) {
 	if err == nil {
 		return
 	}
 
 	var sericeErr = &models.ServiceError{}
 	if errors.As(err, &sericeErr) {
-		s.logger.WithFields(
+		logging.FromContext(ctx, s.logger).WithFields(
 			logrus.Fields{
 				"error.function.name": functionName,
 				"error.msg":           sericeErr.Msg,
@@ -251,7 +294,7 @@ func (s *ScreeningsService) logError(err error, functionName string) {
 			},
 		).Error("screenings service error occurred")
 	} else {
-		s.logger.WithFields(
+		logging.FromContext(ctx, s.logger).WithFields(
 			logrus.Fields{
 				"error.function.name": functionName,
 				"error.msg":           err.Error(),
@@ -278,7 +321,7 @@ func (s *ScreeningsService) handleError(ctx context.Context, err *error, functio
 	}
 
 	e := *err
-	s.logError(*err, functionName)
+	s.logError(ctx, *err, functionName)
 	switch status.Code(*err) {
 	case codes.Canceled:
 		*err = models.Error(models.Canceled, e.Error())
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/Falokut/email_service/internal/metrics"
+	"github.com/Falokut/email_service/internal/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	renderDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "templates",
+		Name:      "render_duration_seconds",
+		Help:      "Duration of the mail template rendering.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25},
+	}, []string{"template"})
+
+	skippedWalletPasses = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "wallet",
+		Name:      "skipped_passes_total",
+		Help:      "Number of the wallet passes which couldn't be built and were left out of the mail.",
+	})
+)
+
+// render executes the template and measures rendering duration.
+func (s *mailService) render(ctx context.Context, w io.Writer, name string, data any) (err error) {
+	_, span := tracer.Start(ctx, "render", trace.WithAttributes(attribute.String("template", name)))
+	start := time.Now()
+	defer func() {
+		renderDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+		tracing.EndSpan(span, err)
+	}()
+	return s.temp.ExecuteTemplate(w, name, data)
+}
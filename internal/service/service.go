@@ -3,55 +3,50 @@ package service
 import (
 	"bytes"
 	"context"
-	"encoding/base64"
 	"fmt"
+	"html/template"
 	"image"
 	"image/png"
-	"text/template"
+	"net/url"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/Falokut/email_service/internal/eticket"
+	"github.com/Falokut/email_service/internal/ical"
 	"github.com/Falokut/email_service/internal/models"
+	"github.com/Falokut/email_service/internal/tracing"
 	"github.com/Falokut/email_service/internal/utils"
+	"github.com/Falokut/email_service/internal/wallet"
+	"github.com/Falokut/email_service/pkg/logging"
 	"github.com/boombuler/barcode"
 	"github.com/boombuler/barcode/code128"
 	"github.com/boombuler/barcode/qr"
 	"github.com/k3a/html2text"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-type TokenTopic int32
-
-const (
-	EmailVerificationTopic TokenTopic = iota
-	PasswordChangingTopic
-)
-
-func (t TokenTopic) MailSubjectType() MailSubjectType {
-	switch t {
-	case EmailVerificationTopic:
-		return EmailVerfication
-	case PasswordChangingTopic:
-		return PasswordChanging
-	}
-
-	return PasswordChanging
+// MailTemplate is the subject and the html template name of the mail.
+type MailTemplate struct {
+	Subject string
+	Name    string
 }
 
 type MailService interface {
-	SendTokenToEmail(ctx context.Context, email, url string, topic TokenTopic, urlTtl time.Duration) error
-	SendOrderCreatedNotification(ctx context.Context, email string, order models.Order) error
+	SendTokenToEmail(ctx context.Context, email, url string, mail MailTemplate, urlTtl time.Duration) error
+	SendOrderCreatedNotification(ctx context.Context, email string, order models.Order, mail MailTemplate) error
 }
 
-type MailSubjectType string
-
-const (
-	EmailVerfication MailSubjectType = "EMAIL_VERIFICATION"
-	PasswordChanging MailSubjectType = "CHANGING_PASSWORD"
-	OrderCreated     MailSubjectType = "ORDER_CREATED"
-)
-
 type MailSender interface {
-	SendEmail(ctx context.Context, email string, subject string, emailBody, altBody string) error
+	SendEmail(ctx context.Context, mail models.Mail) error
+}
+type PassBuilder interface {
+	// Build makes signed wallet pass for the ticket
+	Build(order models.Order, ticket models.TicketNotification, screening models.Screening) ([]byte, error)
 }
+
 type ScreeningService interface {
 	GetScreeningInfo(ctx context.Context, screeningId int64) (models.Screening, error)
 }
@@ -59,21 +54,20 @@ type ScreeningService interface {
 type mailService struct {
 	mailSender       MailSender
 	screeningService ScreeningService
-	temp             *template.Template
-	Subjects         map[MailSubjectType]string
-	TemplatesNames   map[MailSubjectType]string
+	// optional, nil if wallet passes disabled
+	passBuilder PassBuilder
+	temp        *template.Template
+	logger      *logrus.Logger
 }
 
-const (
-	templatesOrigin = "templates"
-)
-
+// NewMailService parses the html templates of the templatesDir.
 func NewMailService(
 	mailSender MailSender,
 	screeningService ScreeningService,
-	Subjects map[MailSubjectType]string,
-	TemplatesNames map[MailSubjectType]string) (*mailService, error) {
-	temp, err := template.ParseGlob(fmt.Sprintf("%s/*.html", templatesOrigin))
+	templatesDir string,
+	passBuilder PassBuilder,
+	logger *logrus.Logger) (*mailService, error) {
+	temp, err := template.ParseGlob(filepath.Join(templatesDir, "*.html"))
 	if err != nil {
 		return nil, err
 	}
@@ -81,17 +75,19 @@ func NewMailService(
 	return &mailService{
 		mailSender:       mailSender,
 		screeningService: screeningService,
-		Subjects:         Subjects,
-		TemplatesNames:   TemplatesNames,
+		passBuilder:      passBuilder,
 		temp:             temp,
+		logger:           logger,
 	}, nil
 }
-func (s *mailService) SendTokenToEmail(ctx context.Context, email, url string, topic TokenTopic, urlTtl time.Duration) (err error) {
+func (s *mailService) SendTokenToEmail(ctx context.Context, email, url string, mail MailTemplate, urlTtl time.Duration) (err error) {
+	if err = validateCallbackURL(url); err != nil {
+		return
+	}
 
-	subject := s.Subjects[topic.MailSubjectType()]
 	var body bytes.Buffer
 
-	err = s.temp.ExecuteTemplate(&body, s.TemplatesNames[topic.MailSubjectType()], struct {
+	err = s.render(ctx, &body, mail.Name, struct {
 		URL string
 		TTL string
 	}{
@@ -103,10 +99,27 @@ func (s *mailService) SendTokenToEmail(ctx context.Context, email, url string, t
 		return
 	}
 
-	err = s.mailSender.SendEmail(ctx, email, subject, body.String(), html2text.HTML2Text(body.String()))
+	err = s.mailSender.SendEmail(ctx, models.Mail{
+		To:       []string{email},
+		Subject:  mail.Subject,
+		HTMLBody: body.String(),
+		TextBody: html2text.HTML2Text(body.String()),
+	})
 	return
 }
 
+// validateCallbackURL checks that the link, which will be placed into the mail, is an absolute http(s) url.
+func validateCallbackURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return models.Errorf(models.InvalidArgument, "invalid callback url: %s", err)
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return models.Error(models.InvalidArgument, "callback url must be an absolute http or https url")
+	}
+	return nil
+}
+
 func GetBarCode(id string) (img image.Image, err error) {
 	bc, err := code128.Encode(id)
 	if err != nil {
@@ -135,33 +148,104 @@ func GetQrCode(id string) (img image.Image, err error) {
 	return scaled, nil
 }
 
-func toBase64(img image.Image) string {
+// inlinePNG encodes the image as inline png attachment with the specified content id.
+func inlinePNG(img image.Image, contentID string) (models.Attachment, error) {
 	buff := new(bytes.Buffer)
-	png.Encode(buff, img)
-	return base64.StdEncoding.EncodeToString(buff.Bytes())
+	if err := png.Encode(buff, img); err != nil {
+		return models.Attachment{}, err
+	}
+
+	return models.Attachment{
+		Name:        contentID,
+		ContentType: "image/png",
+		Content:     buff.Bytes(),
+		ContentID:   contentID,
+	}, nil
+}
+
+func ticketBarCode(ticketId, contentID string) (models.Attachment, error) {
+	barcode, err := GetBarCode(ticketId)
+	if err != nil {
+		return models.Attachment{}, err
+	}
+	return inlinePNG(barcode, contentID)
+}
+
+const (
+	defaultScreeningDuration = 2 * time.Hour
+	screeningReminder        = time.Hour
+)
+
+// screeningEvent makes calendar event for the ordered screening.
+func screeningEvent(order models.Order, screening models.Screening) ical.Event {
+	duration := screening.Duration
+	if duration <= 0 {
+		duration = defaultScreeningDuration
+	}
+
+	seats := make([]string, 0, len(order.Tickets))
+	for _, ticket := range order.Tickets {
+		seats = append(seats, fmt.Sprintf("ряд %d место %d", ticket.Place.Row, ticket.Place.Seat))
+	}
+
+	event := ical.Event{
+		UID:         order.Id + "@email_service",
+		Start:       screening.Start,
+		Duration:    duration,
+		Summary:     screening.MovieName,
+		Description: fmt.Sprintf("Зал %s: %s", screening.HallName, strings.Join(seats, "; ")),
+		Location:    strings.TrimPrefix(screening.Cinema.Name+", "+screening.Cinema.Address, ", "),
+		Reminder:    screeningReminder,
+	}
+	if screening.Cinema.Coordinates != (models.Coordinates{}) {
+		event.Geo = &screening.Cinema.Coordinates
+	}
+	return event
 }
 
 type orderCreatedNotification struct {
-	OrderId   string
-	OrderIdQR string
-	Screening models.Screening
-	Tickets   []models.TicketNotification
+	OrderId string
+	// content id of the inline order qr code image
+	OrderQRCID string
+	Screening  models.Screening
+	Tickets    []models.TicketNotification
+}
+
+// formatPrice formats the price in kopecks, the same string is shown in the mail and in the pdf tickets.
+// Ruble sign isn't used, go fonts of the pdf tickets have no glyph for it.
+func formatPrice(kopecks uint32) string {
+	return fmt.Sprintf("%d.%02d руб.", kopecks/100, kopecks%100)
 }
 
 func (s *mailService) SendOrderCreatedNotification(ctx context.Context,
-	email string, order models.Order) (err error) {
-	subject := s.Subjects[OrderCreated]
+	email string, order models.Order, mail MailTemplate) (err error) {
+
+	_, span := tracer.Start(ctx, "barcode", trace.WithAttributes(attribute.String("barcode.type", "qr")))
+	qrCode, err := GetQrCode(order.Id)
+	if err != nil {
+		tracing.EndSpan(span, err)
+		return
+	}
+	qrCodeImg, err := inlinePNG(qrCode, "qr.png")
+	tracing.EndSpan(span, err)
+	if err != nil {
+		return
+	}
 
-	qrCode, _ := GetQrCode(order.Id)
+	inline := []models.Attachment{qrCodeImg}
+	tickets := make([]eticket.Ticket, 0, len(order.Tickets))
 	var notification orderCreatedNotification = orderCreatedNotification{
-		OrderId:   order.Id,
-		OrderIdQR: toBase64(qrCode),
+		OrderId:    order.Id,
+		OrderQRCID: qrCodeImg.ContentID,
 	}
 
 	errCh := make(chan error, 1)
 	go func() {
 		defer close(errCh)
+		ctx, span := tracer.Start(ctx, "screening lookup",
+			trace.WithAttributes(attribute.Int64("screening.id", order.ScreeningId)))
 		screening, err := s.screeningService.GetScreeningInfo(ctx, order.ScreeningId)
+		tracing.EndSpan(span, err)
 		if err != nil {
 			errCh <- err
 			return
@@ -170,13 +254,30 @@ func (s *mailService) SendOrderCreatedNotification(ctx context.Context,
 	}()
 
 	for i := range order.Tickets {
-		barcode, _ := GetBarCode(order.Tickets[i].Id)
+		_, span := tracer.Start(ctx, "barcode", trace.WithAttributes(
+			attribute.String("barcode.type", "code128"), attribute.String("ticket.id", order.Tickets[i].Id)))
+		barcodeImg, err := ticketBarCode(order.Tickets[i].Id, fmt.Sprintf("ticket-%d.png", i))
+		tracing.EndSpan(span, err)
+		if err != nil {
+			<-errCh
+			return err
+		}
+
+		inline = append(inline, barcodeImg)
+		price := formatPrice(order.Tickets[i].Price)
 		notification.Tickets = append(notification.Tickets, models.TicketNotification{
-			Id:        order.Tickets[i].Id,
-			IdBarCode: toBase64(barcode),
-			Row:       order.Tickets[i].Place.Row,
-			Seat:      order.Tickets[i].Place.Seat,
-			Price:     fmt.Sprintf("%d.%02d", order.Tickets[i].Price/100, order.Tickets[i].Price%100),
+			Id:         order.Tickets[i].Id,
+			BarCodeCID: barcodeImg.ContentID,
+			Row:        order.Tickets[i].Place.Row,
+			Seat:       order.Tickets[i].Place.Seat,
+			Price:      price,
+		})
+		tickets = append(tickets, eticket.Ticket{
+			Id:      order.Tickets[i].Id,
+			Row:     order.Tickets[i].Place.Row,
+			Seat:    order.Tickets[i].Place.Seat,
+			Price:   price,
+			BarCode: barcodeImg.Content,
 		})
 	}
 	err = <-errCh
@@ -184,12 +285,54 @@ func (s *mailService) SendOrderCreatedNotification(ctx context.Context,
 		return
 	}
 
+	ticketsPDF, err := eticket.NewPDF(order.Id, notification.Screening, tickets)
+	if err != nil {
+		return
+	}
+	attachments := []models.Attachment{
+		{Name: "tickets.pdf", ContentType: "application/pdf", Content: ticketsPDF},
+	}
+	// start is zero if the screening service couldn't parse it, such invite would point to the year 1
+	if !notification.Screening.Start.IsZero() {
+		invite := screeningEvent(order, notification.Screening)
+		attachments = append(attachments, models.Attachment{
+			Name:        "screening.ics",
+			ContentType: ical.ContentType,
+			Content:     invite.Marshal(),
+		})
+	}
+
+	if s.passBuilder != nil {
+		for i := range notification.Tickets {
+			pass, err := s.passBuilder.Build(order, notification.Tickets[i], notification.Screening)
+			if err != nil {
+				// pass is optional, so the mail is sent without it
+				skippedWalletPasses.Inc()
+				logging.FromContext(ctx, s.logger).WithError(err).Warnf("can't build wallet pass for the ticket %s, skipping it",
+					notification.Tickets[i].Id)
+				continue
+			}
+			attachments = append(attachments, models.Attachment{
+				Name:        fmt.Sprintf("ticket-%d.pkpass", i+1),
+				ContentType: wallet.ContentType,
+				Content:     pass,
+			})
+		}
+	}
+
 	var body bytes.Buffer
-	err = s.temp.ExecuteTemplate(&body, s.TemplatesNames[OrderCreated], notification)
+	err = s.render(ctx, &body, mail.Name, notification)
 	if err != nil {
 		return
 	}
 
-	err = s.mailSender.SendEmail(ctx, email, subject, body.String(), html2text.HTML2Text(body.String()))
+	err = s.mailSender.SendEmail(ctx, models.Mail{
+		To:          []string{email},
+		Subject:     mail.Subject,
+		HTMLBody:    body.String(),
+		TextBody:    html2text.HTML2Text(body.String()),
+		Inline:      inline,
+		Attachments: attachments,
+	})
 	return
 }
@@ -0,0 +1,251 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Falokut/email_service/internal/models"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+)
+
+// templates of the repository
+const testTemplatesDir = "../../templates"
+
+type fakeMailSender struct {
+	sent []models.Mail
+}
+
+func (s *fakeMailSender) SendEmail(ctx context.Context, mail models.Mail) error {
+	s.sent = append(s.sent, mail)
+	return nil
+}
+
+type fakeScreeningService struct {
+	screening models.Screening
+}
+
+func (s fakeScreeningService) GetScreeningInfo(ctx context.Context, screeningId int64) (models.Screening, error) {
+	return s.screening, nil
+}
+
+type fakePassBuilder struct {
+	err error
+}
+
+func (b fakePassBuilder) Build(order models.Order, ticket models.TicketNotification, screening models.Screening) ([]byte, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	return []byte("pass " + ticket.Id), nil
+}
+
+var orderMail = MailTemplate{Subject: "Order", Name: "orderCreatedNotification.html"}
+
+func newTestService(t *testing.T, screening models.Screening) (MailService, *fakeMailSender) {
+	t.Helper()
+	return newTestServiceWithPasses(t, screening, nil)
+}
+
+func newTestServiceWithPasses(t *testing.T, screening models.Screening, passBuilder PassBuilder) (MailService, *fakeMailSender) {
+	t.Helper()
+	sender := &fakeMailSender{}
+	s, err := NewMailService(sender, fakeScreeningService{screening: screening}, testTemplatesDir, passBuilder, logrus.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, sender
+}
+
+func TestOrderNotificationEscapesScreening(t *testing.T) {
+	s, sender := newTestService(t, models.Screening{
+		StartTime: "19:30",
+		StartDate: "01.05",
+		MovieName: `<script>alert("title")</script>`,
+		Cinema: models.Cinema{
+			Name:    `<b>Cinema</b>`,
+			Address: `"><img src=x onerror=alert(1)>`,
+		},
+		HallName: `<a href="javascript:alert(1)">Hall</a>`,
+	})
+
+	order := models.Order{
+		Id:          `" onerror="alert(1)`,
+		ScreeningId: 1,
+		Date:        time.Now(),
+		Tickets:     []models.Ticket{{Id: "ticket", Place: models.Place{Row: 1, Seat: 2}, Price: 35000}},
+	}
+	if err := s.SendOrderCreatedNotification(context.Background(), "user@example.com", order, orderMail); err != nil {
+		t.Fatal(err)
+	}
+	if len(sender.sent) != 1 {
+		t.Fatalf("%d mails sent, want 1", len(sender.sent))
+	}
+
+	body := sender.sent[0].HTMLBody
+	for _, hostile := range []string{"<script>", "<img src=x", `<a href="javascript:`, `alt="" onerror=`} {
+		if strings.Contains(body, hostile) {
+			t.Errorf("html body contains the unescaped %q", hostile)
+		}
+	}
+	for _, escaped := range []string{
+		"&lt;script&gt;alert(&#34;title&#34;)&lt;/script&gt;",
+		"&#34;&gt;&lt;img src=x onerror=alert(1)&gt;",
+		"&lt;a href=&#34;javascript:alert(1)&#34;&gt;Hall&lt;/a&gt;",
+		`alt="&#34; onerror=&#34;alert(1)"`,
+	} {
+		if !strings.Contains(body, escaped) {
+			t.Errorf("html body doesn't contain the escaped %q", escaped)
+		}
+	}
+	// images are referenced as the inline parts, not replaced with the unsafe url placeholder
+	inline := sender.sent[0].Inline
+	if strings.Contains(body, "ZgotmplZ") || len(inline) != 2 {
+		t.Fatalf("%d inline parts, want the qr code and the barcode", len(inline))
+	}
+	for _, part := range inline {
+		if !strings.Contains(body, `src="cid:`+part.ContentID+`"`) {
+			t.Errorf("inline part %s isn't referenced from the html body", part.ContentID)
+		}
+	}
+}
+
+func TestValidateCallbackURL(t *testing.T) {
+	tests := []struct {
+		url   string
+		valid bool
+	}{
+		{url: "https://cinema.local/activate?token=abc", valid: true},
+		{url: "http://cinema.local/activate", valid: true},
+		{url: "javascript:alert(1)"},
+		{url: "JavaScript:alert(1)"},
+		{url: " javascript:alert(1)"},
+		{url: "data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg=="},
+		{url: "vbscript:msgbox(1)"},
+		{url: "file:///etc/passwd"},
+		{url: "ftp://cinema.local/activate"},
+		{url: "//cinema.local/activate"},
+		{url: "/activate?token=abc"},
+		{url: "https:///activate"},
+		{url: ""},
+	}
+	for _, tt := range tests {
+		err := validateCallbackURL(tt.url)
+		if tt.valid {
+			if err != nil {
+				t.Errorf("url %q: error %v, want nil", tt.url, err)
+			}
+			continue
+		}
+		if models.Code(err) != models.InvalidArgument {
+			t.Errorf("url %q: error %v, want invalid argument", tt.url, err)
+		}
+	}
+}
+
+func TestSendTokenRejectsUnsafeCallbackURL(t *testing.T) {
+	s, sender := newTestService(t, models.Screening{})
+
+	mail := MailTemplate{Subject: "Account activation", Name: "accountActivation.html"}
+	err := s.SendTokenToEmail(context.Background(), "user@example.com", "javascript:alert(1)", mail, time.Hour)
+	if models.Code(err) != models.InvalidArgument {
+		t.Fatalf("error %v, want invalid argument", err)
+	}
+	if len(sender.sent) != 0 {
+		t.Fatalf("%d mails sent with the unsafe callback url", len(sender.sent))
+	}
+
+	const callback = `https://cinema.local/activate?token=a&next="><script>`
+	err = s.SendTokenToEmail(context.Background(), "user@example.com", callback, mail, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sender.sent) != 1 {
+		t.Fatalf("%d mails sent, want 1", len(sender.sent))
+	}
+	body := sender.sent[0].HTMLBody
+	if strings.Contains(body, "<script>") || strings.Contains(body, `next="`) {
+		t.Error("callback url breaks out of the href attribute")
+	}
+	if !strings.Contains(body, `href="https://cinema.local/activate?token=a&amp;next=%22%3e%3cscript%3e"`) {
+		t.Errorf("html body doesn't contain the escaped callback url")
+	}
+}
+
+func TestOrderNotificationInvite(t *testing.T) {
+	tests := []struct {
+		name       string
+		start      time.Time
+		wantInvite bool
+	}{
+		{name: "known start", start: time.Date(2024, 5, 1, 19, 30, 0, 0, time.UTC), wantInvite: true},
+		// start time of the screening isn't parsed
+		{name: "zero start"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, sender := newTestService(t, models.Screening{Start: tt.start, MovieName: "Фильм"})
+			order := models.Order{
+				Id:          "order-1",
+				ScreeningId: 1,
+				Tickets:     []models.Ticket{{Id: "ticket", Place: models.Place{Row: 1, Seat: 2}, Price: 35000}},
+			}
+			if err := s.SendOrderCreatedNotification(context.Background(), "user@example.com", order, orderMail); err != nil {
+				t.Fatal(err)
+			}
+
+			var invite bool
+			for _, attachment := range sender.sent[0].Attachments {
+				invite = invite || attachment.Name == "screening.ics"
+			}
+			if invite != tt.wantInvite {
+				t.Errorf("invite is attached: %t, want %t", invite, tt.wantInvite)
+			}
+		})
+	}
+}
+
+func TestOrderNotificationWalletPasses(t *testing.T) {
+	tests := []struct {
+		name        string
+		builder     fakePassBuilder
+		wantPasses  []string
+		wantSkipped float64
+	}{
+		{name: "built", wantPasses: []string{"ticket-1.pkpass", "ticket-2.pkpass"}},
+		{name: "failed", builder: fakePassBuilder{err: errors.New("bad certificate")}, wantSkipped: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, sender := newTestServiceWithPasses(t, models.Screening{MovieName: "Фильм"}, tt.builder)
+			order := models.Order{
+				Id:          "order-1",
+				ScreeningId: 1,
+				Tickets: []models.Ticket{
+					{Id: "first", Place: models.Place{Row: 1, Seat: 2}, Price: 35000},
+					{Id: "second", Place: models.Place{Row: 1, Seat: 3}, Price: 35000},
+				},
+			}
+			skipped := testutil.ToFloat64(skippedWalletPasses)
+			if err := s.SendOrderCreatedNotification(context.Background(), "user@example.com", order, orderMail); err != nil {
+				t.Fatal(err)
+			}
+
+			var passes []string
+			for _, attachment := range sender.sent[0].Attachments {
+				if strings.HasSuffix(attachment.Name, ".pkpass") {
+					passes = append(passes, attachment.Name)
+				}
+			}
+			if strings.Join(passes, ",") != strings.Join(tt.wantPasses, ",") {
+				t.Errorf("passes: got %v, want %v", passes, tt.wantPasses)
+			}
+			if got := testutil.ToFloat64(skippedWalletPasses) - skipped; got != tt.wantSkipped {
+				t.Errorf("skipped passes: got %v, want %v", got, tt.wantSkipped)
+			}
+		})
+	}
+}
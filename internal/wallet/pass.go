@@ -0,0 +1,240 @@
+package wallet
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Falokut/email_service/internal/models"
+)
+
+const ContentType = "application/vnd.apple.pkpass"
+
+type PassConfig struct {
+	Enabled            bool   `yaml:"enabled" env:"WALLET_PASS_ENABLED"`
+	PassTypeIdentifier string `yaml:"pass_type_identifier" env:"WALLET_PASS_TYPE_IDENTIFIER"`
+	TeamIdentifier     string `yaml:"team_identifier" env:"WALLET_TEAM_IDENTIFIER"`
+	OrganizationName   string `yaml:"organization_name" env:"WALLET_ORGANIZATION_NAME"`
+	// pem encoded pass type certificate and its private key
+	CertificatePath string `yaml:"certificate_path" env:"WALLET_CERTIFICATE_PATH"`
+	KeyPath         string `yaml:"key_path" env:"WALLET_KEY_PATH"`
+	// pem encoded Apple WWDR intermediate certificate, may be empty for local test certificates
+	WWDRCertificatePath string `yaml:"wwdr_certificate_path" env:"WALLET_WWDR_CERTIFICATE_PATH"`
+	// directory with pass images: icon.png, icon@2x.png, logo.png, logo@2x.png
+	ImagesDir string `yaml:"images_dir" env:"WALLET_IMAGES_DIR"`
+}
+
+var passImages = []string{"icon.png", "icon@2x.png", "logo.png", "logo@2x.png"}
+
+// PassBuilder builds signed Apple Wallet passes for the tickets.
+type PassBuilder struct {
+	cfg    PassConfig
+	cert   *x509.Certificate
+	key    crypto.PrivateKey
+	wwdr   *x509.Certificate
+	images map[string][]byte
+}
+
+func NewPassBuilder(cfg PassConfig) (*PassBuilder, error) {
+	if cfg.PassTypeIdentifier == "" || cfg.TeamIdentifier == "" {
+		return nil, errors.New("pass type identifier and team identifier must be specified")
+	}
+
+	b := &PassBuilder{cfg: cfg, images: make(map[string][]byte)}
+	var err error
+	b.cert, b.key, err = loadKeyPair(cfg.CertificatePath, cfg.KeyPath)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.WWDRCertificatePath != "" {
+		if b.wwdr, err = loadCertificate(cfg.WWDRCertificatePath); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range passImages {
+		if cfg.ImagesDir == "" {
+			break
+		}
+		img, err := os.ReadFile(filepath.Join(cfg.ImagesDir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		b.images[name] = img
+	}
+
+	// icon is required by the wallet
+	if _, ok := b.images["icon.png"]; !ok {
+		b.images["icon.png"] = defaultIcon()
+	}
+	return b, nil
+}
+
+type field struct {
+	Key   string `json:"key"`
+	Label string `json:"label,omitempty"`
+	Value string `json:"value"`
+}
+
+type barcode struct {
+	Format          string `json:"format"`
+	Message         string `json:"message"`
+	MessageEncoding string `json:"messageEncoding"`
+	AltText         string `json:"altText,omitempty"`
+}
+
+type location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+type eventTicket struct {
+	PrimaryFields   []field `json:"primaryFields"`
+	SecondaryFields []field `json:"secondaryFields"`
+	AuxiliaryFields []field `json:"auxiliaryFields"`
+	BackFields      []field `json:"backFields"`
+}
+
+type pass struct {
+	FormatVersion      int         `json:"formatVersion"`
+	PassTypeIdentifier string      `json:"passTypeIdentifier"`
+	SerialNumber       string      `json:"serialNumber"`
+	TeamIdentifier     string      `json:"teamIdentifier"`
+	OrganizationName   string      `json:"organizationName"`
+	Description        string      `json:"description"`
+	RelevantDate       string      `json:"relevantDate,omitempty"`
+	ExpirationDate     string      `json:"expirationDate,omitempty"`
+	Locations          []location  `json:"locations,omitempty"`
+	Barcodes           []barcode   `json:"barcodes"`
+	EventTicket        eventTicket `json:"eventTicket"`
+}
+
+// Build makes signed .pkpass bundle for the ticket.
+func (b *PassBuilder) Build(order models.Order, ticket models.TicketNotification, screening models.Screening) ([]byte, error) {
+	p := b.newPass(order, ticket, screening)
+	passJSON, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string][]byte{"pass.json": passJSON}
+	for name, img := range b.images {
+		files[name] = img
+	}
+
+	manifest := make(map[string]string, len(files))
+	for name, content := range files {
+		sum := sha1.Sum(content)
+		manifest[name] = hex.EncodeToString(sum[:])
+	}
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	files["manifest.json"] = manifestJSON
+
+	signature, err := b.sign(manifestJSON)
+	if err != nil {
+		return nil, err
+	}
+	files["signature"] = signature
+
+	var buff bytes.Buffer
+	w := zip.NewWriter(&buff)
+	for name, content := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = f.Write(content); err != nil {
+			return nil, err
+		}
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+func (b *PassBuilder) newPass(order models.Order, ticket models.TicketNotification, screening models.Screening) pass {
+	code := barcode{
+		Format:          "PKBarcodeFormatCode128",
+		Message:         ticket.Id,
+		MessageEncoding: "iso-8859-1",
+		AltText:         ticket.Id,
+	}
+	// code128 isn't supported on apple watch, so wallet falls back to the qr code
+	qrCode := code
+	qrCode.Format = "PKBarcodeFormatQR"
+
+	p := pass{
+		FormatVersion:      1,
+		PassTypeIdentifier: b.cfg.PassTypeIdentifier,
+		SerialNumber:       ticket.Id,
+		TeamIdentifier:     b.cfg.TeamIdentifier,
+		OrganizationName:   b.cfg.OrganizationName,
+		Description:        "Билет в кино",
+		Barcodes:           []barcode{code, qrCode},
+		EventTicket: eventTicket{
+			PrimaryFields: []field{{Key: "movie", Label: "Фильм", Value: screening.MovieName}},
+			SecondaryFields: []field{
+				{Key: "hall", Label: "Зал", Value: screening.HallName},
+				{Key: "row", Label: "Ряд", Value: fmt.Sprint(ticket.Row)},
+				{Key: "seat", Label: "Место", Value: fmt.Sprint(ticket.Seat)},
+			},
+			AuxiliaryFields: []field{
+				{Key: "date", Label: "Дата", Value: screening.StartDate},
+				{Key: "time", Label: "Время", Value: screening.StartTime},
+				{Key: "price", Label: "Цена", Value: ticket.Price},
+			},
+			BackFields: []field{
+				{Key: "cinema", Label: "Кинотеатр", Value: screening.Cinema.Name},
+				{Key: "address", Label: "Адрес", Value: screening.Cinema.Address},
+				{Key: "order", Label: "Заказ", Value: order.Id},
+			},
+		},
+	}
+
+	if !screening.Start.IsZero() {
+		p.RelevantDate = screening.Start.Format(time.RFC3339)
+		if screening.Duration > 0 {
+			p.ExpirationDate = screening.Start.Add(screening.Duration).Format(time.RFC3339)
+		}
+	}
+	if screening.Cinema.Coordinates != (models.Coordinates{}) {
+		p.Locations = []location{{
+			Latitude:  screening.Cinema.Coordinates.Lat,
+			Longitude: screening.Cinema.Coordinates.Long,
+		}}
+	}
+	return p
+}
+
+func defaultIcon() []byte {
+	const size = 29
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for x := 0; x < size; x++ {
+		for y := 0; y < size; y++ {
+			img.Set(x, y, color.RGBA{R: 0x7c, G: 0x72, B: 0xdc, A: 0xff})
+		}
+	}
+
+	var buff bytes.Buffer
+	png.Encode(&buff, img)
+	return buff.Bytes()
+}
@@ -0,0 +1,63 @@
+package wallet
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+
+	"go.mozilla.org/pkcs7"
+)
+
+// sign makes detached PKCS#7 signature of the pass manifest.
+func (b *PassBuilder) sign(manifest []byte) ([]byte, error) {
+	sd, err := pkcs7.NewSignedData(manifest)
+	if err != nil {
+		return nil, err
+	}
+	sd.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+
+	if b.wwdr != nil {
+		err = sd.AddSignerChain(b.cert, b.key, []*x509.Certificate{b.wwdr}, pkcs7.SignerInfoConfig{})
+	} else {
+		err = sd.AddSigner(b.cert, b.key, pkcs7.SignerInfoConfig{})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sd.Detach()
+	return sd.Finish()
+}
+
+func loadKeyPair(certPath, keyPath string) (*x509.Certificate, crypto.PrivateKey, error) {
+	pair, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, pair.PrivateKey, nil
+}
+
+func loadCertificate(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		// Apple distributes WWDR certificate in DER encoding
+		return x509.ParseCertificate(data)
+	}
+	if block.Type != "CERTIFICATE" {
+		return nil, errors.New("wwdr certificate: unexpected pem block " + block.Type)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
@@ -0,0 +1,171 @@
+package wallet
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Falokut/email_service/internal/models"
+	"go.mozilla.org/pkcs7"
+)
+
+// writeTestKeyPair writes pem encoded self-signed certificate and its key into the dir.
+func writeTestKeyPair(t *testing.T, dir string) (certPath, keyPath string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "pass.local.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPath, keyPath = filepath.Join(dir, "pass.pem"), filepath.Join(dir, "pass.key")
+	err = os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath
+}
+
+func readArchive(t *testing.T, archive []byte) map[string][]byte {
+	t.Helper()
+	r, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := make(map[string][]byte, len(r.File))
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = content
+	}
+	return files
+}
+
+func TestBuild(t *testing.T) {
+	certPath, keyPath := writeTestKeyPair(t, t.TempDir())
+	builder, err := NewPassBuilder(PassConfig{
+		PassTypeIdentifier: "pass.local.test",
+		TeamIdentifier:     "TEAM",
+		OrganizationName:   "Cinema",
+		CertificatePath:    certPath,
+		KeyPath:            keyPath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, 5, 1, 19, 30, 0, 0, time.UTC)
+	archive, err := builder.Build(
+		models.Order{Id: "order-1"},
+		models.TicketNotification{Id: "ticket-1", Row: 3, Seat: 7, Price: "350.00 руб."},
+		models.Screening{MovieName: "Movie", HallName: "1", Start: start, Duration: 2 * time.Hour},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := readArchive(t, archive)
+
+	for _, name := range []string{"pass.json", "manifest.json", "signature", "icon.png"} {
+		if len(files[name]) == 0 {
+			t.Fatalf("archive has no %s", name)
+		}
+	}
+
+	var manifest map[string]string
+	if err = json.Unmarshal(files["manifest.json"], &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest) != len(files)-2 {
+		t.Errorf("manifest has %d files, want %d", len(manifest), len(files)-2)
+	}
+	for name, content := range files {
+		if name == "manifest.json" || name == "signature" {
+			continue
+		}
+		sum := sha1.Sum(content)
+		if got, want := manifest[name], hex.EncodeToString(sum[:]); got != want {
+			t.Errorf("manifest hash of %s: got %q, want %q", name, got, want)
+		}
+	}
+
+	p7, err := pkcs7.Parse(files["signature"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p7.Content) != 0 {
+		t.Error("signature isn't detached")
+	}
+	p7.Content = files["manifest.json"]
+	if err = p7.Verify(); err != nil {
+		t.Errorf("signature of the manifest: %v", err)
+	}
+	p7.Content = append(files["manifest.json"], ' ')
+	if err = p7.Verify(); err == nil {
+		t.Error("signature verified for the modified manifest")
+	}
+
+	var p pass
+	if err = json.Unmarshal(files["pass.json"], &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.SerialNumber != "ticket-1" {
+		t.Errorf("serial number: got %q, want %q", p.SerialNumber, "ticket-1")
+	}
+	if want := start.Format(time.RFC3339); p.RelevantDate != want {
+		t.Errorf("relevant date: got %q, want %q", p.RelevantDate, want)
+	}
+	if want := start.Add(2 * time.Hour).Format(time.RFC3339); p.ExpirationDate != want {
+		t.Errorf("expiration date: got %q, want %q", p.ExpirationDate, want)
+	}
+	if got := p.EventTicket.AuxiliaryFields[2].Value; got != "350.00 руб." {
+		t.Errorf("price: got %q, want %q", got, "350.00 руб.")
+	}
+}
+
+func TestNewPassBuilderRequiresIdentifiers(t *testing.T) {
+	certPath, keyPath := writeTestKeyPair(t, t.TempDir())
+	_, err := NewPassBuilder(PassConfig{CertificatePath: certPath, KeyPath: keyPath})
+	if err == nil {
+		t.Error("got nil error, want error for empty identifiers")
+	}
+}
@@ -0,0 +1,34 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+)
+
+// Load sets the root CAs of the client config from the pem encoded CA bundle
+// and the client certificate from the pem encoded certificate and key files.
+// Empty caFile keeps the system pool, empty certFile and keyFile keep the config without client certificate.
+func Load(cfg *tls.Config, caFile, certFile, keyFile string) error {
+	if caFile != "" {
+		ca, err := os.ReadFile(caFile)
+		if err != nil {
+			return err
+		}
+
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(ca) {
+			return errors.New("no certificates found in the CA bundle " + caFile)
+		}
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package logging
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+type entryKey struct{}
+
+// FromContext returns the entry stored in the context by ContextWithFields,
+// if there is no such entry, the entry of the logger is returned.
+func FromContext(ctx context.Context, logger *logrus.Logger) *logrus.Entry {
+	if e, ok := ctx.Value(entryKey{}).(*logrus.Entry); ok {
+		return e.WithContext(ctx)
+	}
+	return logrus.NewEntry(logger).WithContext(ctx)
+}
+
+// ContextWithFields returns the context, which carries the entry with the fields
+// added to the fields of the entry already stored in the ctx.
+func ContextWithFields(ctx context.Context, logger *logrus.Logger, fields logrus.Fields) context.Context {
+	return context.WithValue(ctx, entryKey{}, FromContext(ctx, logger).WithFields(fields))
+}
+
+// HashRecipient returns the short stable hash of the email address,
+// which allows to correlate the logs of one recipient without writing the address itself.
+func HashRecipient(email string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return hex.EncodeToString(sum[:8])
+}
@@ -6,6 +6,8 @@ import (
 	"os"
 	"path"
 	"runtime"
+	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/sirupsen/logrus/hooks/test"
@@ -22,6 +24,24 @@ const (
 	FileAndConsoleOutput
 )
 
+type Format int
+
+const (
+	TextFormat Format = iota
+	JSONFormat
+)
+
+// ParseFormat converts the format name (text or json) into the Format.
+func ParseFormat(format string) (Format, error) {
+	switch strings.ToLower(format) {
+	case "", "text":
+		return TextFormat, nil
+	case "json":
+		return JSONFormat, nil
+	}
+	return TextFormat, fmt.Errorf("unsupported log format %q", format)
+}
+
 type Logger struct {
 	*logrus.Entry
 }
@@ -38,17 +58,28 @@ func (l *Logger) GetLoggerWithField(k string, v interface{}) Logger {
 	return Logger{l.WithField(k, v)}
 }
 
-func NewEntry(mode Mode) {
+func NewEntry(mode Mode, format Format) {
 	l := logrus.New()
 	l.SetReportCaller(true)
 
-	l.Formatter = &logrus.TextFormatter{
-		CallerPrettyfier: func(f *runtime.Frame) (string, string) {
-			filename := path.Base(f.File)
-			return fmt.Sprintf("%s:%d", filename, f.Line), fmt.Sprintf("%s()", f.Function)
-		},
-		DisableColors: false,
-		FullTimestamp: true,
+	switch format {
+	case JSONFormat:
+		l.Formatter = &logrus.JSONFormatter{
+			CallerPrettyfier: func(f *runtime.Frame) (string, string) {
+				filename := path.Base(f.File)
+				return fmt.Sprintf("%s()", f.Function), fmt.Sprintf("%s:%d", filename, f.Line)
+			},
+			TimestampFormat: time.RFC3339Nano,
+		}
+	default:
+		l.Formatter = &logrus.TextFormatter{
+			CallerPrettyfier: func(f *runtime.Frame) (string, string) {
+				filename := path.Base(f.File)
+				return fmt.Sprintf("%s:%d", filename, f.Line), fmt.Sprintf("%s()", f.Function)
+			},
+			DisableColors: false,
+			FullTimestamp: true,
+		}
 	}
 
 	switch mode {